@@ -0,0 +1,155 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/tcp"
+)
+
+// locally running daemon with mutual TLS and access control list
+const defaultACLServer = "127.0.0.1:63430"
+
+// newACLClient returns a client of the daemon with access control list, authenticated with token if not empty.
+func newACLClient(t *testing.T, token string) client.Client {
+	if os.Getenv("ACL_FILE") == "" {
+		t.Skip("no access control list file specified")
+	}
+	a, err := net.ResolveTCPAddr("tcp", defaultACLServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := tcp.NewTLS(a, testTLSConfig(t, true), time.Second*3, time.Second*2, time.Second*2)
+	if token != "" {
+		c.(client.Authenticator).SetToken(token)
+	}
+	return c
+}
+
+func expectForbidden(t *testing.T, err error) {
+	if e, ok := err.(*client.Error); !ok || e.Result != api.Forbidden {
+		t.Fatal("expected Forbidden, got", err)
+	}
+}
+
+func TestACLEnforced(t *testing.T) {
+	c := newACLClient(t, "team-a-secret")
+	defer c.Close()
+	name := generateLockName(t)
+
+	// allowed by token
+	l, err := c.Acquire("team-a-" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Acquire("team-b-" + name)
+	expectForbidden(t, err)
+
+	// allowed by client certificate, only for some commands
+	l, err = c.Acquire("tls-" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectForbidden(t, l.Verify())
+	err = l.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// allowed for everybody
+	_, err = c.IsLocked("team-b-" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without token
+	anonymous := newACLClient(t, "")
+	defer anonymous.Close()
+	_, err = anonymous.Acquire("team-a-" + name)
+	expectForbidden(t, err)
+}
+
+func TestACLUnknownToken(t *testing.T) {
+	c := newACLClient(t, "wrong-secret")
+	defer c.Close()
+
+	_, err := c.IsLocked(generateLockName(t))
+	expectForbidden(t, err)
+}
+
+func TestACLReload(t *testing.T) {
+	c := newACLClient(t, "")
+	defer c.Close()
+	lockName := "team-b-" + generateLockName(t)
+
+	_, err := c.Acquire(lockName)
+	expectForbidden(t, err)
+
+	path := os.Getenv("ACL_FILE")
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(os.Getenv("ACL_DAEMON_PID"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reload := func(acl []byte) {
+		err := ioutil.WriteFile(path, acl, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = syscall.Kill(pid, syscall.SIGHUP)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer reload(original)
+
+	reload(append(original, []byte("cn:test-client team-b- *\n")...))
+
+	// reload is asynchronous
+	for i := 0; i < 100; i++ {
+		var l *client.Lock
+		l, err = c.Acquire(lockName)
+		if err == nil {
+			err = l.Release()
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
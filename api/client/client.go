@@ -17,7 +17,9 @@ with this program; if not, write to the Free Software Foundation, Inc.,
 */
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/gdm85/distrilock/api"
 )
@@ -36,21 +38,72 @@ type Client interface {
 	Close() error
 }
 
+// ContextClient is implemented by clients whose calls can be cancelled through a context; the context deadline applies to connection,
+// handshake and request. Cancelling a request in flight closes the connection, thus releasing the locks of the session, unless the client is pipelined.
+type ContextClient interface {
+	Client
+	// AcquireContext is like Acquire, but it is interrupted when ctx is done.
+	AcquireContext(ctx context.Context, lockName string) (*Lock, error)
+	// ReleaseContext is like Release, but it is interrupted when ctx is done.
+	ReleaseContext(ctx context.Context, l *Lock) error
+	// IsLockedContext is like IsLocked, but it is interrupted when ctx is done.
+	IsLockedContext(ctx context.Context, lockName string) (bool, error)
+	// VerifyContext is like Verify, but it is interrupted when ctx is done.
+	VerifyContext(ctx context.Context, l *Lock) error
+}
+
+// Authenticator is implemented by clients which can authenticate their sessions with a shared-secret token, checked by the daemon against its access control list.
+type Authenticator interface {
+	// SetToken sets the token presented at the start of each new connection.
+	SetToken(token string)
+}
+
+// EventReceiver is implemented by clients which can receive the events pushed by the daemon, e.g. ShuttingDown.
+type EventReceiver interface {
+	// SetEventHandler sets the function called with each event pushed by the daemon on connections established afterwards;
+	// it is called from the goroutine reading responses, thus it must not block.
+	SetEventHandler(handler func(ev *api.LockResponse))
+}
+
+// ConnWatcher is implemented by clients which report the interruption of their connection; pipelined clients detect it as soon as it happens,
+// the others as soon as a request fails on the connection.
+type ConnWatcher interface {
+	// ConnDone returns a channel which is closed when the current connection is interrupted, or nil if no connection is established.
+	ConnDone() <-chan struct{}
+}
+
+// Do sends a request to the daemon and returns its response.
+type Do func(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error)
+
+// Interceptor is called in place of sending each request, which it can inspect, alter or fail; it must call next to actually send it.
+type Interceptor func(ctx context.Context, req *api.LockRequest, next Do) (*api.LockResponse, error)
+
+// Interceptable is implemented by clients whose requests can be intercepted, e.g. for logging, metrics, tracing or fault injection.
+type Interceptable interface {
+	// SetInterceptors sets the interceptors of the requests sent afterwards, handshakes excluded; the first interceptor is the outermost.
+	SetInterceptors(interceptors ...Interceptor)
+}
+
 // Error is the composite error return by all client method calls.
 type Error struct {
 	Result api.LockCommandResult
 	Reason string
 }
 
-// Error returns the associated summary of the ClientError e.
+// Error returns the associated summary of the ClientError e; detailed results are summarised as their legacy result, so that the text does not depend on the daemon protocol version.
 func (e *Error) Error() string {
-	return fmt.Sprintf("%v: %s", e.Result, e.Reason)
+	return fmt.Sprintf("%v: %s", e.Result.Legacy(), e.Reason)
 }
 
 // Lock is a client-specific acquired lock object.
 type Lock struct {
 	Client
 	Name string
+
+	// monitor verifies the lock in background, if started.
+	monitor *monitor
+	// released is set to 1 once the lock is released through its short-hands; accessed atomically.
+	released uint32
 }
 
 // String returns the lock name and the associated client.
@@ -58,12 +111,89 @@ func (l *Lock) String() string {
 	return fmt.Sprintf("%s on %v", l.Name, l.Client)
 }
 
-// Release is a short-hand to call Client.Release for Lock l.
+// Release is a short-hand to call Client.Release for Lock l; the lock monitor, if started, is stopped first.
+// Releasing again a lock released through its short-hands fails with an error matching ErrReleased, without contacting the daemon.
 func (l *Lock) Release() error {
-	return l.Client.Release(l)
+	l.stopMonitor()
+	if atomic.LoadUint32(&l.released) != 0 {
+		return errReleased
+	}
+	return l.setReleased(l.Client.Release(l))
+}
+
+// setReleased records the release of the lock if err is nil, and returns err.
+func (l *Lock) setReleased(err error) error {
+	if err == nil {
+		atomic.StoreUint32(&l.released, 1)
+	}
+	return err
 }
 
 // Verify is a short-hand to call Client.Verify for Lock l.
 func (l *Lock) Verify() error {
 	return l.Client.Verify(l)
 }
+
+// ReleaseContext is a short-hand to call ContextClient.ReleaseContext for Lock l; if the client does not support contexts, ctx is only checked before the call.
+// The lock monitor, if started, is stopped first.
+func (l *Lock) ReleaseContext(ctx context.Context) error {
+	l.stopMonitor()
+	if atomic.LoadUint32(&l.released) != 0 {
+		return errReleased
+	}
+	return l.setReleased(ReleaseContext(ctx, l.Client, l))
+}
+
+// VerifyContext is a short-hand to call ContextClient.VerifyContext for Lock l; if the client does not support contexts, ctx is only checked before the call.
+func (l *Lock) VerifyContext(ctx context.Context) error {
+	return VerifyContext(ctx, l.Client, l)
+}
+
+// NewLock returns a lock named lockName whose short-hands go through c; clients wrapping other clients use it to return their own locks.
+func NewLock(c Client, lockName string) *Lock {
+	return &Lock{Client: c, Name: lockName}
+}
+
+// AcquireContext acquires the named lock through c; if c does not support contexts, ctx is only checked before the call.
+func AcquireContext(ctx context.Context, c Client, lockName string) (*Lock, error) {
+	if cc, ok := c.(ContextClient); ok {
+		return cc.AcquireContext(ctx, lockName)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Acquire(lockName)
+}
+
+// ReleaseContext releases l through c; if c does not support contexts, ctx is only checked before the call.
+func ReleaseContext(ctx context.Context, c Client, l *Lock) error {
+	if cc, ok := c.(ContextClient); ok {
+		return cc.ReleaseContext(ctx, l)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Release(l)
+}
+
+// IsLockedContext returns true if the named lock is acquired, through c; if c does not support contexts, ctx is only checked before the call.
+func IsLockedContext(ctx context.Context, c Client, lockName string) (bool, error) {
+	if cc, ok := c.(ContextClient); ok {
+		return cc.IsLockedContext(ctx, lockName)
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return c.IsLocked(lockName)
+}
+
+// VerifyContext verifies l through c; if c does not support contexts, ctx is only checked before the call.
+func VerifyContext(ctx context.Context, c Client, l *Lock) error {
+	if cc, ok := c.(ContextClient); ok {
+		return cc.VerifyContext(ctx, l)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Verify(l)
+}
@@ -52,8 +52,8 @@ func TestAcquireContentionNFS(t *testing.T) {
 				t.Error("expected client error, got", err)
 				return
 			}
-			if e.Result != api.Failed {
-				t.Error("expected Failed error, got", e.Result)
+			if e.Result != api.HeldByOtherProcess {
+				t.Error("expected HeldByOtherProcess error, got", e.Result)
 				return
 			}
 
@@ -301,8 +301,8 @@ func TestAcquireContention(t *testing.T) {
 				t.Error("expected client error")
 				return
 			}
-			if e.Result != api.Failed {
-				t.Error("expected Failed error, got", e.Result)
+			if e.Result != api.HeldByOtherSession {
+				t.Error("expected HeldByOtherSession error, got", e.Result)
 				return
 			}
 
@@ -458,8 +458,8 @@ func TestAcquireContentionDiffProc(t *testing.T) {
 				t.Error("expected client error")
 				return
 			}
-			if e.Result != api.Failed {
-				t.Error("expected Failed error, got", e.Result)
+			if e.Result != api.HeldByOtherProcess {
+				t.Error("expected HeldByOtherProcess error, got", e.Result)
 				return
 			}
 
@@ -0,0 +1,368 @@
+// Package clienttest provides an in-process fake distrilock daemon, so that packages depending on client.Client can be unit tested without
+// running daemons; locks are held in memory with the same semantics as package core, including idempotent acquisitions and MySession.
+// Its clients are built on the same base as the TCP and Websockets clients, thus they also track held locks and accept interceptors.
+package clienttest
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/internal/base"
+)
+
+// ErrDisconnected is returned for requests interrupted by a simulated connection loss.
+var ErrDisconnected = errors.New("clienttest: connection interrupted")
+
+var validLockNameRx = regexp.MustCompile(`^[A-Za-z0-9.\-_]+$`)
+
+// outcomeRetention is how long the outcome of an acquisition is remembered for retries, as by package core.
+const outcomeRetention = time.Minute
+
+// Daemon is a fake daemon; each of its clients has its own session, which holds locks until released or disconnected.
+type Daemon struct {
+	mu sync.Mutex
+	// holders are the sessions holding each lock.
+	holders map[string]*session
+	// owners are the owner IDs of the requests which acquired each lock, if any.
+	owners map[string]string
+	// foreign are the locks held by a simulated different process.
+	foreign map[string]bool
+	// outcomes are the recent acquisition outcomes, by owner ID and request ID.
+	outcomes map[string]map[uint64]outcome
+	latency  time.Duration
+	clients  []*Client
+}
+
+// session is a simulated connection.
+type session struct {
+	done chan struct{}
+}
+
+// outcome is the recorded result of an acquisition request.
+type outcome struct {
+	lockName string
+	result   api.LockCommandResult
+	reason   string
+	at       time.Time
+}
+
+// NewDaemon returns a new fake daemon with no locks held.
+func NewDaemon() *Daemon {
+	return &Daemon{
+		holders:  map[string]*session{},
+		owners:   map[string]string{},
+		foreign:  map[string]bool{},
+		outcomes: map[string]map[uint64]outcome{},
+	}
+}
+
+// NewClient returns a new concurrency-safe client of d; as with pipelined clients, cancelling a request does not interrupt its session.
+func (d *Daemon) NewClient() *Client {
+	t := &transport{d: d}
+	c := &Client{sessionClient: bclient.New(t).(sessionClient), t: t}
+	d.mu.Lock()
+	d.clients = append(d.clients, c)
+	d.mu.Unlock()
+	return c
+}
+
+// SetLatency sets the time taken by the daemon to answer each request, to simulate slow responses.
+func (d *Daemon) SetLatency(latency time.Duration) {
+	d.mu.Lock()
+	d.latency = latency
+	d.mu.Unlock()
+}
+
+// HoldForeign simulates the named lock being acquired by a different process, e.g. another daemon sharing the directory; if a session
+// already holds the lock, it is lost as when the file lock is lost, and Verify reports it held by the other process.
+func (d *Daemon) HoldForeign(lockName string) {
+	d.mu.Lock()
+	d.foreign[lockName] = true
+	d.mu.Unlock()
+}
+
+// ReleaseForeign releases the named lock held by a different process.
+func (d *Daemon) ReleaseForeign(lockName string) {
+	d.mu.Lock()
+	delete(d.foreign, lockName)
+	d.mu.Unlock()
+}
+
+// IsLocked returns true if the named lock is held by any session or by a different process.
+func (d *Daemon) IsLocked(lockName string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, held := d.holders[lockName]
+	return held || d.foreign[lockName]
+}
+
+// DisconnectAll simulates the loss of the connections of all clients, as when the daemon is restarted.
+func (d *Daemon) DisconnectAll() {
+	d.mu.Lock()
+	clients := append([]*Client(nil), d.clients...)
+	d.mu.Unlock()
+
+	for _, c := range clients {
+		c.Disconnect()
+	}
+}
+
+// disconnect releases all the locks of s; d.mu must be held.
+func (d *Daemon) disconnect(s *session) {
+	for name, holder := range d.holders {
+		if holder == s {
+			d.release(name)
+		}
+	}
+	close(s.done)
+}
+
+// release releases the named lock; d.mu must be held.
+func (d *Daemon) release(lockName string) {
+	delete(d.holders, lockName)
+	delete(d.owners, lockName)
+}
+
+// process processes the request for session s, as core.ProcessRequest does.
+func (d *Daemon) process(s *session, req *api.LockRequest) *api.LockResponse {
+	var res api.LockResponse
+	res.LockRequest = *req
+	res.VersionMajor, res.VersionMinor = api.VersionMajor, api.VersionMinor
+	res.Token = ""
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if req.Command == api.MySession {
+		res.Result = api.Success
+		for name, holder := range d.holders {
+			if holder == s {
+				res.LockNames = append(res.LockNames, name)
+			}
+		}
+		sort.Strings(res.LockNames)
+		return &res
+	}
+
+	if !validLockNameRx.MatchString(req.LockName) {
+		res.Result, res.Reason = api.InvalidLockName, "invalid lock name"
+		return &res
+	}
+
+	holder, held := d.holders[req.LockName]
+	switch req.Command {
+	case api.Acquire:
+		res.Result, res.Reason = d.acquireOnce(s, req)
+	case api.Release:
+		switch {
+		case !held:
+			res.Result, res.Reason = api.NotHeld, "lock not found"
+		case holder != s:
+			res.Result, res.Reason = api.HeldByOtherSession, "resource acquired through a different session"
+		default:
+			d.release(req.LockName)
+			res.Result = api.Success
+		}
+	case api.Peek:
+		res.Result, res.IsLocked = api.Success, held || d.foreign[req.LockName]
+	case api.Verify:
+		switch {
+		case !held:
+			res.Result, res.Reason = api.NotHeld, "lock not found"
+		case holder != s:
+			res.Result, res.Reason = api.HeldByOtherSession, "resource acquired through a different session"
+		case d.foreign[req.LockName]:
+			res.Result, res.Reason = api.HeldByOtherProcess, "resource acquired by different process"
+		default:
+			res.Result = api.Success
+		}
+	default:
+		res.Result, res.Reason = api.BadRequest, "unknown command"
+	}
+	return &res
+}
+
+// acquireOnce makes acquisitions idempotent as core does: a retried acquisition is answered with the outcome of the original request,
+// and the lock it acquired now belongs to the retrying session; d.mu must be held.
+func (d *Daemon) acquireOnce(s *session, req *api.LockRequest) (api.LockCommandResult, string) {
+	if req.OwnerID == "" || req.RequestID == 0 {
+		return d.acquire(s, req)
+	}
+
+	o, ok := d.outcomes[req.OwnerID][req.RequestID]
+	if ok && o.lockName == req.LockName && time.Since(o.at) < outcomeRetention {
+		if o.result != api.Success {
+			return o.result, o.reason
+		}
+		if _, held := d.holders[req.LockName]; held && d.owners[req.LockName] == req.OwnerID {
+			d.holders[req.LockName] = s
+			return o.result, o.reason
+		}
+		// lock was lost meanwhile, thus it is acquired again
+	}
+
+	result, reason := d.acquire(s, req)
+
+	outcomes := d.outcomes[req.OwnerID]
+	if outcomes == nil {
+		outcomes = map[uint64]outcome{}
+		d.outcomes[req.OwnerID] = outcomes
+	}
+	now := time.Now()
+	for id, o := range outcomes {
+		if now.Sub(o.at) >= outcomeRetention {
+			delete(outcomes, id)
+		}
+	}
+	outcomes[req.RequestID] = outcome{lockName: req.LockName, result: result, reason: reason, at: now}
+
+	return result, reason
+}
+
+// acquire acquires the named lock for session s; d.mu must be held.
+func (d *Daemon) acquire(s *session, req *api.LockRequest) (api.LockCommandResult, string) {
+	holder, held := d.holders[req.LockName]
+	switch {
+	case held && holder != s:
+		return api.HeldByOtherSession, "resource acquired through a different session"
+	case held:
+		return api.Success, "no-op"
+	case d.foreign[req.LockName]:
+		return api.HeldByOtherProcess, "resource acquired by different process"
+	}
+	d.holders[req.LockName] = s
+	if req.OwnerID != "" {
+		d.owners[req.LockName] = req.OwnerID
+	}
+	return api.Success, ""
+}
+
+// sessionClient is implemented by the clients of package bclient.
+type sessionClient interface {
+	client.ContextClient
+	client.SessionTracker
+	client.Interceptable
+	client.ConnWatcher
+}
+
+// Client is a client of a fake daemon; it establishes a new session at the first request after being disconnected or closed.
+type Client struct {
+	sessionClient
+	t *transport
+}
+
+// String returns a summary of the client session.
+func (c *Client) String() string {
+	c.t.mu.Lock()
+	defer c.t.mu.Unlock()
+	return fmt.Sprintf("clienttest session %p", c.t.s)
+}
+
+// Disconnect simulates the loss of the connection: the locks of the session are released and requests in flight are interrupted.
+func (c *Client) Disconnect() {
+	_ = c.t.Close()
+}
+
+// transport is the simulated connection of a client to the fake daemon.
+type transport struct {
+	d *Daemon
+
+	mu sync.Mutex
+	s  *session
+}
+
+// AcquireConn establishes a new session, if none is established yet.
+func (t *transport) AcquireConn(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	if t.s == nil {
+		t.s = &session{done: make(chan struct{})}
+	}
+	t.mu.Unlock()
+	return nil
+}
+
+// Do sends the request on the current session and waits for the latency of the daemon.
+func (t *transport) Do(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error) {
+	t.mu.Lock()
+	s := t.s
+	t.mu.Unlock()
+	if s == nil {
+		return nil, ErrDisconnected
+	}
+
+	t.d.mu.Lock()
+	latency := t.d.latency
+	t.d.mu.Unlock()
+	if latency != 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-s.done:
+			return nil, ErrDisconnected
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case <-s.done:
+		return nil, ErrDisconnected
+	default:
+	}
+	return t.d.process(s, req), nil
+}
+
+// Close releases all the locks of the session.
+func (t *transport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.s == nil {
+		return nil
+	}
+	t.d.mu.Lock()
+	t.d.disconnect(t.s)
+	t.d.mu.Unlock()
+	t.s = nil
+	return nil
+}
+
+// ConnDone returns a channel which is closed when the current session is disconnected, or nil if no session is established.
+func (t *transport) ConnDone() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.s == nil {
+		return nil
+	}
+	return t.s.done
+}
+
+// KeepsConnOnTimeout returns true as a cancelled request does not affect the session.
+func (t *transport) KeepsConnOnTimeout() bool {
+	return true
+}
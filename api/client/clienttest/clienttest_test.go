@@ -0,0 +1,250 @@
+package clienttest_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/clienttest"
+)
+
+// interface compliance checks
+var (
+	_ client.ContextClient  = &clienttest.Client{}
+	_ client.ConnWatcher    = &clienttest.Client{}
+	_ client.SessionTracker = &clienttest.Client{}
+	_ client.Interceptable  = &clienttest.Client{}
+)
+
+func TestSessionOwnership(t *testing.T) {
+	d := clienttest.NewDaemon()
+	a, b := d.NewClient(), d.NewClient()
+
+	l, err := a.Acquire("lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// acquisitions are re-entrant within the same session
+	if _, err := a.Acquire("lock"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Acquire("lock"); !errors.Is(err, client.ErrHeldByOtherSession) {
+		t.Fatal("expected ErrHeldByOtherSession, got", err)
+	}
+	if err := b.Release(&client.Lock{Client: b, Name: "lock"}); !errors.Is(err, client.ErrHeldByOtherSession) {
+		t.Fatal("expected ErrHeldByOtherSession, got", err)
+	}
+	if locked, err := b.IsLocked("lock"); err != nil || !locked {
+		t.Fatal("expected lock to be locked, got", locked, err)
+	}
+	if err := l.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Release(); !errors.Is(err, client.ErrNotHeld) {
+		t.Fatal("expected ErrNotHeld, got", err)
+	}
+	if err := l.Verify(); !errors.Is(err, client.ErrNotHeld) {
+		t.Fatal("expected ErrNotHeld, got", err)
+	}
+	if _, err := a.Acquire("invalid/name"); !errors.Is(err, client.ErrInvalidLockName) {
+		t.Fatal("expected ErrInvalidLockName, got", err)
+	}
+}
+
+func TestDisconnectReleasesLocks(t *testing.T) {
+	d := clienttest.NewDaemon()
+	a, b := d.NewClient(), d.NewClient()
+
+	l, err := a.Acquire("lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Monitor(time.Hour, nil)
+
+	d.DisconnectAll()
+
+	select {
+	case err := <-l.Lost():
+		if err != client.ErrConnLost {
+			t.Fatal("expected ErrConnLost, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection loss not detected")
+	}
+	if d.IsLocked("lock") {
+		t.Fatal("lock still held after disconnection")
+	}
+
+	// a new session is established by the next request
+	if _, err := b.Acquire("lock"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Verify(); !errors.Is(err, client.ErrHeldByOtherSession) {
+		t.Fatal("expected ErrHeldByOtherSession, got", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d.IsLocked("lock") {
+		t.Fatal("lock still held after close")
+	}
+}
+
+func TestSlowResponses(t *testing.T) {
+	d := clienttest.NewDaemon()
+	c := d.NewClient()
+	d.SetLatency(time.Millisecond * 200)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := c.AcquireContext(ctx, "lock"); err != context.DeadlineExceeded {
+		t.Fatal("expected deadline exceeded, got", err)
+	}
+
+	// requests in flight are interrupted by disconnection; the session was established by the previous request
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		c.Disconnect()
+	}()
+	if _, err := c.Acquire("lock"); err != clienttest.ErrDisconnected {
+		t.Fatal("expected ErrDisconnected, got", err)
+	}
+	if d.IsLocked("lock") {
+		t.Fatal("lock acquired by interrupted request")
+	}
+}
+
+func TestForeignHolder(t *testing.T) {
+	d := clienttest.NewDaemon()
+	c := d.NewClient()
+
+	d.HoldForeign("foreign")
+	if _, err := c.Acquire("foreign"); !errors.Is(err, client.ErrHeldByOtherProcess) {
+		t.Fatal("expected ErrHeldByOtherProcess, got", err)
+	}
+	if locked, err := c.IsLocked("foreign"); err != nil || !locked {
+		t.Fatal("expected lock to be locked, got", locked, err)
+	}
+	d.ReleaseForeign("foreign")
+	if _, err := c.Acquire("foreign"); err != nil {
+		t.Fatal(err)
+	}
+
+	// lock held by a session is lost to the other process
+	l, err := c.Acquire("stolen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.HoldForeign("stolen")
+	if err := l.Verify(); !errors.Is(err, client.ErrHeldByOtherProcess) {
+		t.Fatal("expected ErrHeldByOtherProcess, got", err)
+	}
+}
+
+func TestSessionTracking(t *testing.T) {
+	d := clienttest.NewDaemon()
+	c := d.NewClient()
+
+	if _, err := c.Acquire("a"); err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.Acquire("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held := c.Held(); len(held) != 2 || held[0].Name != "a" || held[1].State != client.LockHeld {
+		t.Fatal("unexpected held locks", held)
+	}
+	names, err := c.MySession(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Fatal("unexpected session locks", names)
+	}
+
+	// lock lost to a different process
+	d.HoldForeign("b")
+	if err := l.Verify(); !errors.Is(err, client.ErrHeldByOtherProcess) {
+		t.Fatal("expected ErrHeldByOtherProcess, got", err)
+	}
+	if held := c.Held(); held[1].State != client.LockLost {
+		t.Fatal("expected lock b lost, got", held[1])
+	}
+
+	reports, err := c.CloseWithReport(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || reports[0].Err != nil || reports[0].State != client.LockHeld || reports[1].State != client.LockLost {
+		t.Fatal("unexpected release reports", reports)
+	}
+	if d.IsLocked("a") {
+		t.Fatal("lock still held after close")
+	}
+}
+
+// timeoutError is a network timeout error.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIdempotentAcquisition(t *testing.T) {
+	d := clienttest.NewDaemon()
+	a, b := d.NewClient(), d.NewClient()
+
+	l, err := a.Acquire("lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the response of the first acquisition is lost after the lock was released by its holder
+	var dropped bool
+	b.SetInterceptors(func(ctx context.Context, req *api.LockRequest, next client.Do) (*api.LockResponse, error) {
+		res, err := next(ctx, req)
+		if err == nil && req.Command == api.Acquire && !dropped {
+			dropped = true
+			if err := l.Release(); err != nil {
+				t.Error(err)
+			}
+			return nil, timeoutError{}
+		}
+		return res, err
+	})
+
+	// the retry of the same request is answered with the original outcome
+	if _, err := b.Acquire("lock"); !errors.Is(err, client.ErrHeldByOtherSession) {
+		t.Fatal("expected ErrHeldByOtherSession, got", err)
+	}
+	if !dropped {
+		t.Fatal("expected first response to be dropped")
+	}
+
+	// a new request is processed again
+	if _, err := b.Acquire("lock"); err != nil {
+		t.Fatal(err)
+	}
+}
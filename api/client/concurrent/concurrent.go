@@ -16,14 +16,17 @@ with this program; if not, write to the Free Software Foundation, Inc.,
 */
 
 import (
-	"sync"
+	"context"
+	"errors"
 
+	"github.com/gdm85/distrilock/api"
 	"github.com/gdm85/distrilock/api/client"
 )
 
 type concurrentWrapper struct {
-	sync.Mutex
-	c client.Client
+	// sem serialises the use of the wrapped client; unlike a mutex, waiting for it can be interrupted.
+	sem chan struct{}
+	c   client.Client
 }
 
 // New returns a concurrency-safe client using the specified client.
@@ -32,46 +35,215 @@ func New(c client.Client) client.Client {
 		panic("BUG: trying to wrap twice concurrency client")
 	}
 	return &concurrentWrapper{
-		c: c,
+		sem: make(chan struct{}, 1),
+		c:   c,
 	}
 }
 
+// lock waits for exclusive use of the wrapped client.
+func (c *concurrentWrapper) lock() {
+	c.sem <- struct{}{}
+}
+
+// lockContext waits for exclusive use of the wrapped client, unless ctx is done first.
+func (c *concurrentWrapper) lockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case c.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// unlock ends the exclusive use of the wrapped client.
+func (c *concurrentWrapper) unlock() {
+	<-c.sem
+}
+
 // Acquire will acquire a named lock through the distrilock daemon.
 func (c *concurrentWrapper) Acquire(lockName string) (*client.Lock, error) {
-	c.Lock()
+	c.lock()
 	l, err := c.c.Acquire(lockName)
-	c.Unlock()
+	c.unlock()
+	if l != nil {
+		// lock short-hands must go through the wrapper as well
+		l.Client = c
+	}
 	return l, err
 }
 
 // Release will release a locked name previously acquired in this session.
 func (c *concurrentWrapper) Release(l *client.Lock) error {
-	c.Lock()
+	c.lock()
 	err := c.c.Release(l)
-	c.Unlock()
+	c.unlock()
 	return err
 }
 
 // IsLocked returns true when distrilock deamon estabilished that lock is currently acquired.
 func (c *concurrentWrapper) IsLocked(lockName string) (bool, error) {
-	c.Lock()
+	c.lock()
 	b, err := c.c.IsLocked(lockName)
-	c.Unlock()
+	c.unlock()
 	return b, err
 }
 
 // Close will release all active locks and close the connection.
 func (c *concurrentWrapper) Close() error {
-	c.Lock()
+	c.lock()
 	err := c.c.Close()
-	c.Unlock()
+	c.unlock()
 	return err
 }
 
 // Verify will verify that the lock is currently held by the client and healthy.
 func (c *concurrentWrapper) Verify(l *client.Lock) error {
-	c.Lock()
+	c.lock()
 	err := c.c.Verify(l)
-	c.Unlock()
+	c.unlock()
+	return err
+}
+
+// SetToken sets the token presented at the start of each new connection, if supported by the wrapped client.
+func (c *concurrentWrapper) SetToken(token string) {
+	a, ok := c.c.(client.Authenticator)
+	if !ok {
+		return
+	}
+	c.lock()
+	a.SetToken(token)
+	c.unlock()
+}
+
+// SetEventHandler sets the function called with each event pushed by the daemon, if supported by the wrapped client.
+func (c *concurrentWrapper) SetEventHandler(handler func(ev *api.LockResponse)) {
+	er, ok := c.c.(client.EventReceiver)
+	if !ok {
+		return
+	}
+	c.lock()
+	er.SetEventHandler(handler)
+	c.unlock()
+}
+
+// SetInterceptors sets the interceptors of the requests sent afterwards, if supported by the wrapped client.
+func (c *concurrentWrapper) SetInterceptors(interceptors ...client.Interceptor) {
+	i, ok := c.c.(client.Interceptable)
+	if !ok {
+		return
+	}
+	c.lock()
+	i.SetInterceptors(interceptors...)
+	c.unlock()
+}
+
+// Held returns the locks acquired and not released yet, if tracked by the wrapped client.
+func (c *concurrentWrapper) Held() []client.HeldLock {
+	st, ok := c.c.(client.SessionTracker)
+	if !ok {
+		return nil
+	}
+	c.lock()
+	defer c.unlock()
+	return st.Held()
+}
+
+// MySession returns the names of the locks held by the session according to the daemon, if supported by the wrapped client.
+func (c *concurrentWrapper) MySession(ctx context.Context) ([]string, error) {
+	st, ok := c.c.(client.SessionTracker)
+	if !ok {
+		return nil, errors.New("session tracking not supported")
+	}
+	if err := c.lockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+	return st.MySession(ctx)
+}
+
+// CloseWithReport explicitly releases each held lock and closes the connection, if supported by the wrapped client.
+func (c *concurrentWrapper) CloseWithReport(ctx context.Context) ([]client.ReleaseReport, error) {
+	st, ok := c.c.(client.SessionTracker)
+	if !ok {
+		return nil, c.Close()
+	}
+	if err := c.lockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
+	return st.CloseWithReport(ctx)
+}
+
+// ConnDone returns a channel which is closed when the current connection is interrupted, if detected by the wrapped client.
+func (c *concurrentWrapper) ConnDone() <-chan struct{} {
+	cw, ok := c.c.(client.ConnWatcher)
+	if !ok {
+		return nil
+	}
+	c.lock()
+	defer c.unlock()
+	return cw.ConnDone()
+}
+
+// AcquireContext will acquire a named lock through the distrilock daemon, unless ctx is done first.
+func (c *concurrentWrapper) AcquireContext(ctx context.Context, lockName string) (*client.Lock, error) {
+	cc, ok := c.c.(client.ContextClient)
+	if !ok {
+		return c.Acquire(lockName)
+	}
+	if err := c.lockContext(ctx); err != nil {
+		return nil, err
+	}
+	l, err := cc.AcquireContext(ctx, lockName)
+	c.unlock()
+	if l != nil {
+		// lock short-hands must go through the wrapper as well
+		l.Client = c
+	}
+	return l, err
+}
+
+// ReleaseContext will release a locked name previously acquired in this session, unless ctx is done first.
+func (c *concurrentWrapper) ReleaseContext(ctx context.Context, l *client.Lock) error {
+	cc, ok := c.c.(client.ContextClient)
+	if !ok {
+		return c.Release(l)
+	}
+	if err := c.lockContext(ctx); err != nil {
+		return err
+	}
+	err := cc.ReleaseContext(ctx, l)
+	c.unlock()
+	return err
+}
+
+// IsLockedContext returns true when distrilock deamon estabilished that lock is currently acquired, unless ctx is done first.
+func (c *concurrentWrapper) IsLockedContext(ctx context.Context, lockName string) (bool, error) {
+	cc, ok := c.c.(client.ContextClient)
+	if !ok {
+		return c.IsLocked(lockName)
+	}
+	if err := c.lockContext(ctx); err != nil {
+		return false, err
+	}
+	b, err := cc.IsLockedContext(ctx, lockName)
+	c.unlock()
+	return b, err
+}
+
+// VerifyContext will verify that the lock is currently held by the client and healthy, unless ctx is done first.
+func (c *concurrentWrapper) VerifyContext(ctx context.Context, l *client.Lock) error {
+	cc, ok := c.c.(client.ContextClient)
+	if !ok {
+		return c.Verify(l)
+	}
+	if err := c.lockContext(ctx); err != nil {
+		return err
+	}
+	err := cc.VerifyContext(ctx, l)
+	c.unlock()
 	return err
 }
@@ -0,0 +1,167 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/concurrent"
+	"github.com/gdm85/distrilock/api/client/mux"
+	"github.com/gdm85/distrilock/api/client/tcp"
+	"github.com/gdm85/distrilock/api/client/ws"
+)
+
+// newUnresponsiveClients returns a client of each type for a listener which never answers, simulating an unresponsive daemon.
+func newUnresponsiveClients(t *testing.T) (net.Listener, map[string]client.ContextClient) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := l.Addr().(*net.TCPAddr)
+	endpoint := "ws://" + a.String() + "/distrilock"
+
+	clients := map[string]client.ContextClient{}
+	for name, c := range map[string]client.Client{
+		"tcp":       tcp.New(a, time.Second*3, time.Second*15, time.Second*15),
+		"wire":      tcp.NewWire(a, time.Second*3, time.Second*15, time.Second*15),
+		"pipelined": mux.New(a, time.Second*3, time.Second*15, time.Second*15),
+		"ws binary": ws.NewBinary(endpoint, time.Second*3, time.Second*15, time.Second*15),
+		"ws text":   ws.NewJSON(endpoint, time.Second*3, time.Second*15, time.Second*15),
+	} {
+		cc, ok := c.(client.ContextClient)
+		if !ok {
+			t.Fatalf("%s client does not support contexts", name)
+		}
+		clients[name] = cc
+	}
+	return l, clients
+}
+
+func TestContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, cs := range clientSuites {
+		cs := cs
+		t.Run(cs.name, func(t *testing.T) {
+			t.Parallel()
+
+			lockName := generateLockName(t)
+
+			cc, ok := cs.testClientA1.(client.ContextClient)
+			if !ok {
+				t.Error("expected client to support contexts")
+				return
+			}
+			_, err := cc.AcquireContext(ctx, lockName)
+			if err != context.Canceled {
+				t.Error("expected cancellation, got", err)
+				return
+			}
+
+			// client is still usable
+			l, err := cc.AcquireContext(context.Background(), lockName)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			err = l.ReleaseContext(ctx)
+			if err != context.Canceled {
+				t.Error("expected cancellation, got", err)
+			}
+			err = l.ReleaseContext(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	l, clients := newUnresponsiveClients(t)
+	defer l.Close()
+
+	for name, cc := range clients {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		start := time.Now()
+		_, err := cc.AcquireContext(ctx, "unanswered")
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("%s: expected deadline exceeded, got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second*2 {
+			t.Errorf("%s: deadline not respected, returned after %v", name, elapsed)
+		}
+	}
+}
+
+func TestContextCancelMidRequest(t *testing.T) {
+	l, clients := newUnresponsiveClients(t)
+	defer l.Close()
+
+	for name, cc := range clients {
+		ctx, cancel := context.WithCancel(context.Background())
+		timer := time.AfterFunc(time.Millisecond*200, cancel)
+		start := time.Now()
+		_, err := cc.IsLockedContext(ctx, "unanswered")
+		timer.Stop()
+		cancel()
+		if err != context.Canceled {
+			t.Errorf("%s: expected cancellation, got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second*2 {
+			t.Errorf("%s: cancellation not respected, returned after %v", name, elapsed)
+		}
+	}
+}
+
+func TestContextDeadlineWaitingForConcurrentClient(t *testing.T) {
+	l, clients := newUnresponsiveClients(t)
+	defer l.Close()
+	c := concurrent.New(clients["tcp"]).(client.ContextClient)
+
+	// a slow request holds the concurrent client
+	slowCtx, slowCancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer slowCancel()
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := c.AcquireContext(slowCtx, "unanswered")
+		slowDone <- err
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	// a request waiting for the concurrent client returns when its deadline expires
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	_, err := c.IsLockedContext(ctx, "unanswered")
+	if err != context.DeadlineExceeded {
+		t.Error("expected deadline exceeded, got", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("deadline not respected while waiting for the concurrent client, returned after", elapsed)
+	}
+
+	slowCancel()
+	err = <-slowDone
+	if err != context.Canceled {
+		t.Error("expected cancellation of the slow request, got", err)
+	}
+}
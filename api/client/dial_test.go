@@ -0,0 +1,98 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+	_ "github.com/gdm85/distrilock/api/client/tcp"
+	_ "github.com/gdm85/distrilock/api/client/ws"
+)
+
+func TestDialSchemes(t *testing.T) {
+	lockName := generateLockName(t)
+	urls := []string{
+		"tcp://localhost" + defaultServerA,
+		"tcp://localhost" + defaultServerA + "?format=wire",
+		defaultWebsocketServerA,
+		defaultWebsocketServerA + "?format=json",
+	}
+	if dir := unixSocketDir(); dir != "" {
+		urls = append(urls, "unix://"+dir+"/a.sock")
+	}
+
+	for _, u := range urls {
+		u := u
+		t.Run(u, func(t *testing.T) {
+			c, err := client.Dial(context.Background(), u,
+				client.WithReadTimeout(time.Second*2),
+				client.WithDialTimeout(time.Second),
+				client.WithOwnerLabel("dial-test"),
+				client.WithHeader(http.Header{"X-Test": []string{"dial"}}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			l, err := c.Acquire(lockName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = l.Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = l.Release()
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDialErrors(t *testing.T) {
+	for _, u := range []string{"gopher://localhost:70", "tcp://localhost:1?format=xml", "ws://localhost:1/distrilock?format=xml"} {
+		_, err := client.Dial(context.Background(), u)
+		if err == nil {
+			t.Fatal("expected error dialing", u)
+		}
+	}
+
+	// an address where no daemon is listening
+	dl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := dl.Addr().String()
+	_ = dl.Close()
+
+	// connection is established by Dial
+	_, err = client.Dial(context.Background(), "tcp://"+addr)
+	if err == nil {
+		t.Fatal("expected connection error")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.Dial(ctx, defaultWebsocketServerA)
+	if err != context.Canceled {
+		t.Fatal("expected cancellation, got", err)
+	}
+}
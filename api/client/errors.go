@@ -0,0 +1,88 @@
+package client
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gdm85/distrilock/api"
+)
+
+// Sentinel errors matching an *Error through errors.Is.
+var (
+	// ErrHeldByOtherSession matches errors for a lock held by a different session of the same daemon.
+	ErrHeldByOtherSession = errors.New("lock held by a different session")
+	// ErrHeldByOtherProcess matches errors for a lock held by a different process, e.g. another daemon sharing the directory.
+	ErrHeldByOtherProcess = errors.New("lock held by a different process")
+	// ErrNotHeld matches errors for a lock which is not held.
+	ErrNotHeld = errors.New("lock not held")
+	// ErrInvalidLockName matches errors for an invalid lock name.
+	ErrInvalidLockName = errors.New("invalid lock name")
+	// ErrForbidden matches errors for a command not allowed by the daemon access control list.
+	ErrForbidden = errors.New("forbidden")
+	// ErrUnsupported matches errors for a command which is not supported by the protocol version of the daemon.
+	ErrUnsupported = errors.New("command not supported by the daemon")
+	// ErrSessionReset matches errors for a request whose connection had to be closed, releasing the other locks of the session.
+	ErrSessionReset = errors.New("session reset")
+	// ErrReleased matches errors for a lock already released through the same Lock; they also match ErrNotHeld.
+	ErrReleased = errors.New("lock already released")
+)
+
+// errReleased is returned when releasing a lock already released through the same Lock.
+var errReleased = fmt.Errorf("%w: %w", ErrReleased, ErrNotHeld)
+
+// IsNotHeld returns true if err reports that the lock is not held by the session, e.g. because it is held by a different
+// session or process.
+func IsNotHeld(err error) bool {
+	return errors.Is(err, ErrNotHeld) || errors.Is(err, ErrHeldByOtherSession) || errors.Is(err, ErrHeldByOtherProcess)
+}
+
+// Is returns true if target is the sentinel error for the result of e; results of daemons before protocol
+// version 0.5 are matched by their reason.
+func (e *Error) Is(target error) bool {
+	return target != nil && sentinelOf(e.Result, e.Reason) == target
+}
+
+func sentinelOf(result api.LockCommandResult, reason string) error {
+	switch result {
+	case api.HeldByOtherSession:
+		return ErrHeldByOtherSession
+	case api.HeldByOtherProcess:
+		return ErrHeldByOtherProcess
+	case api.NotHeld:
+		return ErrNotHeld
+	case api.InvalidLockName:
+		return ErrInvalidLockName
+	case api.Forbidden:
+		return ErrForbidden
+	case api.Failed:
+		switch {
+		case strings.HasPrefix(reason, "resource acquired through a different session"):
+			return ErrHeldByOtherSession
+		case reason == "resource acquired by different process":
+			return ErrHeldByOtherProcess
+		case reason == "lock not found":
+			return ErrNotHeld
+		}
+	case api.BadRequest:
+		if reason == "invalid lock name" {
+			return ErrInvalidLockName
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+)
+
+func TestErrorSentinels(t *testing.T) {
+	for _, cs := range clientSuites {
+		cs := cs
+		t.Run(cs.name, func(t *testing.T) {
+			t.Parallel()
+
+			lockName := generateLockName(t)
+
+			l, err := cs.testClientA1.Acquire(lockName)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			_, err = cs.testClientA2.Acquire(lockName)
+			if !errors.Is(err, client.ErrHeldByOtherSession) {
+				t.Error("expected lock held by a different session, got", err)
+			}
+			_, err = cs.testClientB1.Acquire(lockName)
+			if !errors.Is(err, client.ErrHeldByOtherProcess) {
+				t.Error("expected lock held by a different process, got", err)
+			}
+
+			err = l.Release()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// releasing again through the same lock fails without contacting the daemon
+			err = l.Release()
+			if !errors.Is(err, client.ErrNotHeld) || !errors.Is(err, client.ErrReleased) {
+				t.Error("expected lock already released, got", err)
+			}
+			err = cs.testClientA1.Release(l)
+			if !errors.Is(err, client.ErrNotHeld) || errors.Is(err, client.ErrReleased) {
+				t.Error("expected lock not held, got", err)
+			}
+			// error text is the same as with daemons before detailed results
+			if err == nil || err.Error() != "Failed: lock not found" {
+				t.Error("expected lock not found, got", err)
+			}
+
+			_, err = cs.testClientA1.Acquire("invalid lock name")
+			if !errors.Is(err, client.ErrInvalidLockName) {
+				t.Error("expected invalid lock name, got", err)
+			}
+		})
+	}
+}
+
+func TestLegacyResults(t *testing.T) {
+	lockName := generateLockName(t)
+
+	holder := newRawSession(t, defaultServerA)
+	defer holder.close()
+	res := holder.do(t, api.LockRequest{Command: api.Acquire, LockName: lockName})
+	if res.Result != api.Success {
+		t.Fatal("expected Success, got", res.Result, res.Reason)
+	}
+
+	// clients before protocol version 0.5 receive the legacy result with the same reason
+	legacy := newRawSession(t, defaultServerA)
+	defer legacy.close()
+	res = legacy.do(t, api.LockRequest{VersionMinor: 4, Command: api.Acquire, LockName: lockName})
+	if res.Result != api.Failed || res.Reason != "resource acquired through a different session" {
+		t.Fatal("expected Failed, got", res.Result, res.Reason)
+	}
+	res = legacy.do(t, api.LockRequest{VersionMinor: 4, Command: api.Release, LockName: "invalid lock name"})
+	if res.Result != api.BadRequest {
+		t.Fatal("expected BadRequest, got", res.Result, res.Reason)
+	}
+
+	// legacy results are still matched by their reason
+	err := &client.Error{Result: res.Result, Reason: res.Reason}
+	if !errors.Is(err, client.ErrInvalidLockName) {
+		t.Fatal("expected invalid lock name, got", err)
+	}
+}
@@ -0,0 +1,385 @@
+// Package failover provides a distrilock client over multiple daemons, which moves to another daemon when the connection to the current one is lost.
+package failover
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+)
+
+// healthCheckLockName is the lock peeked to check the health of endpoints.
+const healthCheckLockName = "distrilock-health-check"
+
+var (
+	// ErrLockLost is returned when releasing or verifying a lock whose session was lost with the connection to its daemon.
+	ErrLockLost = errors.New("lock lost with the connection to its daemon")
+	// errNoHealthyEndpoint is returned when all endpoints are down.
+	errNoHealthyEndpoint = errors.New("no healthy endpoint")
+	errClosed            = errors.New("client closed")
+)
+
+// endpoint is a daemon and the client of its session.
+type endpoint struct {
+	// Mutex serialises the requests to the endpoint, including health checks.
+	sync.Mutex
+	c client.Client
+
+	// healthy and generation are protected by the lock of the failover client.
+	healthy bool
+	// generation is incremented every time the session is lost.
+	generation uint64
+}
+
+// heldLock is a lock acquired through an endpoint in a session of the specified generation.
+type heldLock struct {
+	e          *endpoint
+	generation uint64
+	l          *client.Lock
+}
+
+type failoverClient struct {
+	endpoints     []*endpoint
+	checkInterval time.Duration
+
+	mu sync.Mutex
+	// active is the endpoint where new sessions are established, if any is healthy.
+	active *endpoint
+	locks  map[*client.Lock]heldLock
+	closed bool
+	done   chan struct{}
+}
+
+// New returns a concurrency-safe client for the clients of endpoints, in order of preference; all endpoints are expected to be
+// daemons sharing the same directory, e.g. on NFS. Acquisitions go to the first healthy endpoint and stay there until the connection
+// is lost: then locks held through it are lost and acquisitions move to the next healthy endpoint. Each endpoint is health-checked
+// with a Peek request every checkInterval, so that lost connections are detected also when idle and endpoints which are down
+// become available again once they answer.
+func New(endpoints []client.Client, checkInterval time.Duration) client.Client {
+	if len(endpoints) == 0 {
+		panic("BUG: no endpoints specified")
+	}
+	f := &failoverClient{
+		checkInterval: checkInterval,
+		locks:         map[*client.Lock]heldLock{},
+		done:          make(chan struct{}),
+	}
+	for _, c := range endpoints {
+		// endpoints are assumed healthy until a request fails
+		f.endpoints = append(f.endpoints, &endpoint{c: c, healthy: true})
+	}
+	go f.check()
+	return f
+}
+
+// String returns a summary of the endpoints.
+func (f *failoverClient) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	healthy := 0
+	for _, e := range f.endpoints {
+		if e.healthy {
+			healthy++
+		}
+	}
+	if f.active == nil {
+		return fmt.Sprintf("failover client with %d/%d healthy endpoints", healthy, len(f.endpoints))
+	}
+	return fmt.Sprintf("failover client with %d/%d healthy endpoints, active %v", healthy, len(f.endpoints), f.active.c)
+}
+
+// SetToken sets the token presented at the start of each new connection, if supported by the clients of endpoints.
+func (f *failoverClient) SetToken(token string) {
+	for _, e := range f.endpoints {
+		if a, ok := e.c.(client.Authenticator); ok {
+			e.Lock()
+			a.SetToken(token)
+			e.Unlock()
+		}
+	}
+}
+
+// activeEndpoint returns the endpoint of the current session, or else the first healthy one.
+func (f *failoverClient) activeEndpoint() (*endpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, errClosed
+	}
+	if f.active != nil && f.active.healthy {
+		return f.active, nil
+	}
+	f.active = nil
+	for _, e := range f.endpoints {
+		if e.healthy {
+			f.active = e
+			return e, nil
+		}
+	}
+	return nil, errNoHealthyEndpoint
+}
+
+// call runs fn with the client of e and returns the generation of the session used; when the endpoint fails, its session is
+// lost with all its locks and the connection closed.
+func (f *failoverClient) call(ctx context.Context, e *endpoint, fn func(c client.Client) error) (uint64, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	f.mu.Lock()
+	generation := e.generation
+	f.mu.Unlock()
+
+	err := fn(e.c)
+	if isFailure(ctx, err) {
+		f.mu.Lock()
+		e.healthy = false
+		e.generation++
+		f.mu.Unlock()
+
+		// make sure that the session is terminated, releasing any lock left
+		_ = e.c.Close()
+	}
+	return generation, err
+}
+
+// isFailure returns true if err is neither an answer of the daemon nor caused by ctx.
+func isFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var ce *client.Error
+	return !errors.As(err, &ce)
+}
+
+// check health-checks all endpoints every check interval, until the client is closed.
+func (f *failoverClient) check() {
+	t := time.NewTicker(f.checkInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-t.C:
+			for _, e := range f.endpoints {
+				f.probe(e)
+			}
+		}
+	}
+}
+
+// probe peeks a lock through e; an endpoint which is down becomes healthy once it answers.
+func (f *failoverClient) probe(e *endpoint) {
+	ctx := context.Background()
+	_, err := f.call(ctx, e, func(c client.Client) error {
+		_, err := c.IsLocked(healthCheckLockName)
+		return err
+	})
+	if isFailure(ctx, err) {
+		return
+	}
+
+	f.mu.Lock()
+	e.healthy = true
+	f.mu.Unlock()
+}
+
+// held returns the lock acquired through an endpoint for l; locks not acquired through this client are assigned to the active endpoint.
+func (f *failoverClient) held(l *client.Lock) (heldLock, error) {
+	f.mu.Lock()
+	hl, ok := f.locks[l]
+	f.mu.Unlock()
+	if ok {
+		return hl, nil
+	}
+
+	e, err := f.activeEndpoint()
+	if err != nil {
+		return hl, err
+	}
+	f.mu.Lock()
+	hl = heldLock{e: e, generation: e.generation, l: &client.Lock{Client: e.c, Name: l.Name}}
+	f.mu.Unlock()
+	return hl, nil
+}
+
+// isLost returns true if the session of the lock was lost.
+func (f *failoverClient) isLost(hl heldLock) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return hl.generation != hl.e.generation
+}
+
+// Acquire will acquire a named lock through the distrilock daemon.
+func (f *failoverClient) Acquire(lockName string) (*client.Lock, error) {
+	return f.AcquireContext(context.Background(), lockName)
+}
+
+// AcquireContext will acquire a named lock through the active endpoint, unless ctx is done first; if the endpoint fails,
+// the acquisition is retried on the next healthy endpoint.
+func (f *failoverClient) AcquireContext(ctx context.Context, lockName string) (*client.Lock, error) {
+	var lastErr error
+	for {
+		e, err := f.activeEndpoint()
+		if err != nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, err
+		}
+
+		var el *client.Lock
+		generation, err := f.call(ctx, e, func(c client.Client) error {
+			var err error
+			el, err = client.AcquireContext(ctx, c, lockName)
+			return err
+		})
+		if isFailure(ctx, err) {
+			lastErr = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		l := client.NewLock(f, lockName)
+		f.mu.Lock()
+		f.locks[l] = heldLock{e: e, generation: generation, l: el}
+		f.mu.Unlock()
+		return l, nil
+	}
+}
+
+// Release will release a locked name previously acquired through this client.
+func (f *failoverClient) Release(l *client.Lock) error {
+	return f.ReleaseContext(context.Background(), l)
+}
+
+// ReleaseContext will release a locked name previously acquired through this client, unless ctx is done first;
+// ErrLockLost is returned if its session was lost.
+func (f *failoverClient) ReleaseContext(ctx context.Context, l *client.Lock) error {
+	hl, err := f.held(l)
+	if err != nil {
+		return err
+	}
+	if f.isLost(hl) {
+		f.forget(l)
+		return ErrLockLost
+	}
+
+	_, err = f.call(ctx, hl.e, func(c client.Client) error {
+		return client.ReleaseContext(ctx, c, hl.l)
+	})
+	if isFailure(ctx, err) {
+		f.forget(l)
+		return ErrLockLost
+	}
+	if err == nil || client.IsNotHeld(err) {
+		f.forget(l)
+	}
+	return err
+}
+
+func (f *failoverClient) forget(l *client.Lock) {
+	f.mu.Lock()
+	delete(f.locks, l)
+	f.mu.Unlock()
+}
+
+// IsLocked returns true when distrilock deamon estabilished that lock is currently acquired.
+func (f *failoverClient) IsLocked(lockName string) (bool, error) {
+	return f.IsLockedContext(context.Background(), lockName)
+}
+
+// IsLockedContext returns true when distrilock deamon estabilished that lock is currently acquired, unless ctx is done first;
+// if the active endpoint fails, the request is retried on the next healthy endpoint.
+func (f *failoverClient) IsLockedContext(ctx context.Context, lockName string) (bool, error) {
+	var lastErr error
+	for {
+		e, err := f.activeEndpoint()
+		if err != nil {
+			if lastErr != nil {
+				return false, lastErr
+			}
+			return false, err
+		}
+
+		var b bool
+		_, err = f.call(ctx, e, func(c client.Client) error {
+			var err error
+			b, err = client.IsLockedContext(ctx, c, lockName)
+			return err
+		})
+		if isFailure(ctx, err) {
+			lastErr = err
+			continue
+		}
+		return b, err
+	}
+}
+
+// Verify will verify that the lock is currently held by the client and healthy; ErrLockLost is returned if its session was lost.
+func (f *failoverClient) Verify(l *client.Lock) error {
+	return f.VerifyContext(context.Background(), l)
+}
+
+// VerifyContext will verify that the lock is currently held by the client and healthy, unless ctx is done first;
+// ErrLockLost is returned if its session was lost.
+func (f *failoverClient) VerifyContext(ctx context.Context, l *client.Lock) error {
+	hl, err := f.held(l)
+	if err != nil {
+		return err
+	}
+	if f.isLost(hl) {
+		return ErrLockLost
+	}
+
+	_, err = f.call(ctx, hl.e, func(c client.Client) error {
+		return client.VerifyContext(ctx, c, hl.l)
+	})
+	if isFailure(ctx, err) {
+		return ErrLockLost
+	}
+	return err
+}
+
+// Close stops health checks and closes the connections to all endpoints, thus releasing all locks; the first error is returned.
+func (f *failoverClient) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.done)
+	f.locks = map[*client.Lock]heldLock{}
+	f.mu.Unlock()
+
+	var firstErr error
+	for _, e := range f.endpoints {
+		e.Lock()
+		err := e.c.Close()
+		e.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,270 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/failover"
+	"github.com/gdm85/distrilock/api/client/tcp"
+	"github.com/gdm85/distrilock/api/client/ws"
+)
+
+// proxy forwards connections to a daemon, until closed.
+type proxy struct {
+	l     net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newProxy(t *testing.T, target string) *proxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &proxy{l: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+			p.mu.Lock()
+			p.conns = append(p.conns, conn, upstream)
+			p.mu.Unlock()
+			go func() {
+				_, _ = io.Copy(upstream, conn)
+				_ = upstream.Close()
+			}()
+			go func() {
+				_, _ = io.Copy(conn, upstream)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return p
+}
+
+// close stops the proxy and interrupts all its connections, as if the daemon went down.
+func (p *proxy) close() {
+	_ = p.l.Close()
+	p.interrupt()
+}
+
+// interrupt interrupts all the connections of the proxy, which keeps accepting new ones.
+func (p *proxy) interrupt() {
+	p.mu.Lock()
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.mu.Unlock()
+}
+
+// accepted returns the number of connections accepted by the proxy.
+func (p *proxy) accepted() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns) / 2
+}
+
+// websocketHostPort returns the address of the daemon of a websocket endpoint.
+func websocketHostPort(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		panic(err)
+	}
+	return u.Host
+}
+
+func newEndpointClient(a net.Addr) client.Client {
+	return tcp.New(a.(*net.TCPAddr), time.Second*3, time.Second*2, time.Second*2)
+}
+
+func TestFailoverSkipsDeadEndpoint(t *testing.T) {
+	// an address where no daemon is listening
+	dl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := dl.Addr()
+	_ = dl.Close()
+	a, err := net.ResolveTCPAddr("tcp", defaultServerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := failover.New([]client.Client{newEndpointClient(dead), newEndpointClient(a)}, time.Millisecond*100)
+	defer c.Close()
+
+	l, err := c.Acquire(generateLockName(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailoverLosesLocksOnConnectionLoss(t *testing.T) {
+	p := newProxy(t, defaultServerA)
+	defer p.close()
+	b, err := net.ResolveTCPAddr("tcp", defaultServerB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := failover.New([]client.Client{newEndpointClient(p.l.Addr()), newEndpointClient(b)}, time.Millisecond*100)
+	defer c.Close()
+
+	lockName := generateLockName(t)
+	l, err := c.Acquire(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// daemon of the session goes down; the health check detects it
+	p.close()
+	time.Sleep(time.Millisecond * 300)
+
+	err = l.Verify()
+	if !errors.Is(err, failover.ErrLockLost) {
+		t.Fatal("expected lock lost, got", err)
+	}
+	err = l.Release()
+	if !errors.Is(err, failover.ErrLockLost) {
+		t.Fatal("expected lock lost, got", err)
+	}
+
+	// the lock was released with the lost session, thus it can be acquired through the other daemon
+	l, err = c.Acquire(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wrappingClient wraps the errors of acquisitions, as a client decorating daemon answers would.
+type wrappingClient struct {
+	client.Client
+}
+
+func (w wrappingClient) Acquire(lockName string) (*client.Lock, error) {
+	l, err := w.Client.Acquire(lockName)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring %s: %w", lockName, err)
+	}
+	return l, nil
+}
+
+func TestFailoverKeepsSessionOnWrappedAnswers(t *testing.T) {
+	a, err := net.ResolveTCPAddr("tcp", defaultServerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := net.ResolveTCPAddr("tcp", defaultServerB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := failover.New([]client.Client{wrappingClient{newEndpointClient(a)}, newEndpointClient(b)}, time.Hour)
+	defer c.Close()
+
+	lockName := generateLockName(t)
+	l, err := c.Acquire(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := newEndpointClient(a)
+	defer other.Close()
+	_, err = other.Acquire(lockName + "-other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a daemon answer is not a failure of the endpoint, also when wrapped
+	_, err = c.Acquire(lockName + "-other")
+	if !errors.Is(err, client.ErrHeldByOtherSession) {
+		t.Fatal("expected ErrHeldByOtherSession, got", err)
+	}
+	err = l.Verify()
+	if err != nil {
+		t.Fatal("expected lock still held, got", err)
+	}
+	err = l.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailoverWebsocketRecovery(t *testing.T) {
+	p := newProxy(t, websocketHostPort(defaultWebsocketServerA))
+	defer p.close()
+
+	e := ws.NewBinary("ws://"+p.l.Addr().String()+"/distrilock", time.Second*3, time.Second*2, time.Second*2)
+	c := failover.New([]client.Client{e, ws.NewBinary(defaultWebsocketServerB, time.Second*3, time.Second*2, time.Second*2)}, time.Millisecond*50)
+	defer c.Close()
+
+	l, err := c.Acquire(generateLockName(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// daemon of the session is restarted on the same address
+	p.interrupt()
+
+	for i := 0; ; i++ {
+		err = l.Verify()
+		if errors.Is(err, failover.ErrLockLost) && strings.Contains(fmt.Sprint(c), "2/2 healthy endpoints") {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("endpoint did not recover: %v, %v", err, c)
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+
+	// the endpoint client reconnected
+	_, err = c.Acquire(generateLockName(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,74 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"testing"
+
+	"github.com/gdm85/distrilock/api"
+)
+
+func TestHelloNegotiatesFeatures(t *testing.T) {
+	s := newRawSession(t, defaultServerA)
+	defer s.close()
+
+	res := s.do(t, api.LockRequest{Command: api.Hello, Features: api.FeaturePipelining | api.FeatureCompression})
+	if res.Result != api.Success {
+		t.Fatal("expected Success, got", res.Result, res.Reason)
+	}
+	if res.VersionMajor != api.VersionMajor || res.VersionMinor != api.VersionMinor {
+		t.Fatal("unexpected daemon version", res.VersionMajor, res.VersionMinor)
+	}
+	if res.Features != api.FeaturePipelining {
+		t.Fatal("expected only pipelining to be granted, got", res.Features)
+	}
+
+	// session is usable after the handshake
+	res = s.do(t, api.LockRequest{Command: api.Peek, LockName: generateLockName(t)})
+	if res.Result != api.Success {
+		t.Fatal("expected Success, got", res.Result, res.Reason)
+	}
+
+	// handshake cannot be repeated
+	res = s.do(t, api.LockRequest{Command: api.Hello})
+	if res.Result != api.BadRequest {
+		t.Fatal("expected BadRequest for repeated hello, got", res.Result, res.Reason)
+	}
+}
+
+func TestHelloUnsupportedVersion(t *testing.T) {
+	s := newRawSession(t, defaultServerA)
+	defer s.close()
+
+	res := s.do(t, api.LockRequest{VersionMajor: api.VersionMajor + 1, Command: api.Hello, Features: api.FeaturePipelining})
+	if res.Result != api.UnsupportedVersion {
+		t.Fatal("expected UnsupportedVersion, got", res.Result, res.Reason)
+	}
+	if res.Features != 0 {
+		t.Fatal("expected no features to be granted, got", res.Features)
+	}
+}
+
+func TestRequestUnsupportedVersion(t *testing.T) {
+	s := newRawSession(t, defaultServerA)
+	defer s.close()
+
+	// a request with a superior major version is answered instead of being skipped
+	res := s.do(t, api.LockRequest{VersionMajor: api.VersionMajor + 1, Command: api.Acquire, LockName: generateLockName(t)})
+	if res.Result != api.UnsupportedVersion {
+		t.Fatal("expected UnsupportedVersion, got", res.Result, res.Reason)
+	}
+}
@@ -0,0 +1,283 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+)
+
+// rawSession is a raw gob session to a distrilock daemon, used to simulate misbehaving clients.
+type rawSession struct {
+	conn *net.TCPConn
+	d    *gob.Decoder
+	e    *gob.Encoder
+}
+
+func newRawSession(t *testing.T, address string) *rawSession {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialTCP("tcp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.SetDeadline(time.Now().Add(time.Second * 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &rawSession{conn: conn, d: gob.NewDecoder(conn), e: gob.NewEncoder(conn)}
+}
+
+func (s *rawSession) send(t *testing.T, req api.LockRequest) {
+	if req.VersionMajor == 0 && req.VersionMinor == 0 {
+		req.VersionMajor, req.VersionMinor = api.VersionMajor, api.VersionMinor
+	}
+	err := s.e.Encode(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *rawSession) receive(t *testing.T) api.LockResponse {
+	var res api.LockResponse
+	err := s.d.Decode(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func (s *rawSession) do(t *testing.T, req api.LockRequest) api.LockResponse {
+	s.send(t, req)
+	return s.receive(t)
+}
+
+func (s *rawSession) close() {
+	_ = s.conn.Close()
+}
+
+func TestAcquireRetryAfterDroppedResponse(t *testing.T) {
+	lockName := generateLockName(t)
+	acquire := api.LockRequest{Command: api.Acquire, LockName: lockName, OwnerID: lockName, RequestID: 1}
+
+	s1 := newRawSession(t, defaultServerA)
+	defer s1.close()
+
+	// response is received but considered lost, as it would happen on a read timeout
+	s1.do(t, acquire)
+
+	// retry on a new session
+	s2 := newRawSession(t, defaultServerA)
+	defer s2.close()
+	res := s2.do(t, acquire)
+	if res.Result != api.Success {
+		t.Fatal("expected Success for retried acquisition, got", res.Result, res.Reason)
+	}
+
+	// lock now belongs to the retrying session
+	res = s1.do(t, api.LockRequest{Command: api.Verify, LockName: lockName, OwnerID: lockName, RequestID: 2})
+	if res.Result != api.HeldByOtherSession || res.Reason != "resource acquired through a different session" {
+		t.Fatal("expected lock to be owned by a different session, got", res.Result, res.Reason)
+	}
+	res = s2.do(t, api.LockRequest{Command: api.Release, LockName: lockName, OwnerID: lockName, RequestID: 3})
+	if res.Result != api.Success {
+		t.Fatal("expected Success for release, got", res.Result, res.Reason)
+	}
+}
+
+func TestAcquireRetryAfterDisconnect(t *testing.T) {
+	lockName := generateLockName(t)
+	acquire := api.LockRequest{Command: api.Acquire, LockName: lockName, OwnerID: lockName, RequestID: 1}
+
+	// response is dropped together with the connection, thus lock is released
+	s1 := newRawSession(t, defaultServerA)
+	s1.do(t, acquire)
+	s1.close()
+
+	s2 := newRawSession(t, defaultServerA)
+	defer s2.close()
+	// whether disconnection was processed already or not, the retry must succeed
+	res := s2.do(t, acquire)
+	if res.Result != api.Success {
+		t.Fatal("expected Success for retried acquisition, got", res.Result, res.Reason)
+	}
+
+	// lock is effectively held by the retrying session
+	res = s2.do(t, api.LockRequest{Command: api.Verify, LockName: lockName, OwnerID: lockName, RequestID: 2})
+	if res.Result != api.Success {
+		t.Fatal("expected Success for verify, got", res.Result, res.Reason)
+	}
+	res = s2.do(t, api.LockRequest{Command: api.Release, LockName: lockName, OwnerID: lockName, RequestID: 3})
+	if res.Result != api.Success {
+		t.Fatal("expected Success for release, got", res.Result, res.Reason)
+	}
+}
+
+func TestAcquireRetryReplaysFailure(t *testing.T) {
+	lockName := generateLockName(t)
+
+	holder := newRawSession(t, defaultServerA)
+	defer holder.close()
+	res := holder.do(t, api.LockRequest{Command: api.Acquire, LockName: lockName, OwnerID: "holder-" + lockName, RequestID: 1})
+	if res.Result != api.Success {
+		t.Fatal("expected Success, got", res.Result, res.Reason)
+	}
+
+	contender := newRawSession(t, defaultServerA)
+	defer contender.close()
+	acquire := api.LockRequest{Command: api.Acquire, LockName: lockName, OwnerID: "contender-" + lockName, RequestID: 1}
+	res = contender.do(t, acquire)
+	if res.Result != api.HeldByOtherSession {
+		t.Fatal("expected HeldByOtherSession, got", res.Result, res.Reason)
+	}
+
+	res = holder.do(t, api.LockRequest{Command: api.Release, LockName: lockName, OwnerID: "holder-" + lockName, RequestID: 2})
+	if res.Result != api.Success {
+		t.Fatal("expected Success, got", res.Result, res.Reason)
+	}
+
+	// a retry is answered with the original outcome
+	res = contender.do(t, acquire)
+	if res.Result != api.HeldByOtherSession {
+		t.Fatal("expected original HeldByOtherSession outcome, got", res.Result, res.Reason)
+	}
+
+	// a new request is processed normally
+	acquire.RequestID = 2
+	res = contender.do(t, acquire)
+	if res.Result != api.Success {
+		t.Fatal("expected Success, got", res.Result, res.Reason)
+	}
+}
+
+// timeoutError is a network timeout error.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// dropFirstAcquisition returns an interceptor which reports a timeout in place of the response of the first acquisition.
+func dropFirstAcquisition() client.Interceptor {
+	var dropped bool
+	return func(ctx context.Context, req *api.LockRequest, next client.Do) (*api.LockResponse, error) {
+		res, err := next(ctx, req)
+		if err == nil && req.Command == api.Acquire && !dropped {
+			dropped = true
+			return nil, timeoutError{}
+		}
+		return res, err
+	}
+}
+
+func TestAcquireTimeoutWithHeldLocks(t *testing.T) {
+	for _, cs := range clientSuites {
+		cs := cs
+		c := cs.createLocalClient()
+		ic, ok := c.(client.Interceptable)
+		if !ok {
+			_ = c.Close()
+			continue
+		}
+		t.Run(cs.name, func(t *testing.T) {
+			defer c.Close()
+			lockNameA, lockNameB := generateLockName(t), generateLockName(t)
+
+			a, err := c.Acquire(lockNameA)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ic.SetInterceptors(dropFirstAcquisition())
+			b, err := c.Acquire(lockNameB)
+
+			if cs.clientType == muxClientType {
+				// request is retried on the same connection
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = a.Verify()
+				if err != nil {
+					t.Fatal("expected lock A to be still held, got", err)
+				}
+				err = b.Release()
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = a.Release()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			// connection was closed, releasing lock A
+			if !errors.Is(err, client.ErrSessionReset) {
+				t.Fatal("expected session reset error, got", err)
+			}
+			held := c.(client.SessionTracker).Held()
+			if len(held) != 1 || held[0].Name != lockNameA || held[0].State != client.LockLost {
+				t.Fatal("expected lock A to be reported lost, got", held)
+			}
+			// the closed session could still hold the lock, until its disconnection is processed by the daemon
+			err = a.Verify()
+			if !errors.Is(err, client.ErrNotHeld) && !errors.Is(err, client.ErrHeldByOtherSession) {
+				t.Fatal("expected lock A not held, got", err)
+			}
+		})
+	}
+}
+
+func TestAcquireTimeoutWithoutHeldLocks(t *testing.T) {
+	for _, cs := range clientSuites {
+		cs := cs
+		c := cs.createLocalClient()
+		ic, ok := c.(client.Interceptable)
+		if !ok {
+			_ = c.Close()
+			continue
+		}
+		t.Run(cs.name, func(t *testing.T) {
+			defer c.Close()
+			lockName := generateLockName(t)
+
+			// no lock is lost, thus the request is retried transparently
+			ic.SetInterceptors(dropFirstAcquisition())
+			l, err := c.Acquire(lockName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = l.Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = l.Release()
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
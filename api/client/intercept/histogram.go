@@ -0,0 +1,116 @@
+package intercept
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+)
+
+// DefaultLatencyBounds are the upper bounds of the latency histogram buckets used when none are specified.
+var DefaultLatencyBounds = []time.Duration{
+	time.Millisecond, time.Millisecond * 2, time.Millisecond * 5,
+	time.Millisecond * 10, time.Millisecond * 20, time.Millisecond * 50,
+	time.Millisecond * 100, time.Millisecond * 200, time.Millisecond * 500,
+	time.Second, time.Second * 2, time.Second * 5,
+}
+
+// LatencyHistogram counts request latencies per command in buckets; its Intercept method is an interceptor.
+type LatencyHistogram struct {
+	bounds []time.Duration
+
+	mu        sync.Mutex
+	byCommand map[api.LockCommand]*Histogram
+}
+
+// Histogram is a snapshot of the latencies of a command.
+type Histogram struct {
+	// Bounds are the inclusive upper bounds of all buckets but the last, which has no bound.
+	Bounds []time.Duration
+	// Counts are the number of requests in each bucket; it has one element more than Bounds.
+	Counts []uint64
+	// Count is the total number of requests.
+	Count uint64
+	// Sum is the total latency of all requests.
+	Sum time.Duration
+}
+
+// NewLatencyHistogram returns a histogram with buckets of the specified upper bounds, or DefaultLatencyBounds if none are specified.
+func NewLatencyHistogram(bounds ...time.Duration) *LatencyHistogram {
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBounds
+	}
+	bounds = append([]time.Duration(nil), bounds...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	return &LatencyHistogram{
+		bounds:    bounds,
+		byCommand: map[api.LockCommand]*Histogram{},
+	}
+}
+
+// Intercept sends the request and records its latency, whatever its outcome.
+func (h *LatencyHistogram) Intercept(ctx context.Context, req *api.LockRequest, next client.Do) (*api.LockResponse, error) {
+	start := time.Now()
+	res, err := next(ctx, req)
+	h.observe(req.Command, time.Since(start))
+	return res, err
+}
+
+func (h *LatencyHistogram) observe(command api.LockCommand, d time.Duration) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] })
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hg, ok := h.byCommand[command]
+	if !ok {
+		hg = &Histogram{Bounds: h.bounds, Counts: make([]uint64, len(h.bounds)+1)}
+		h.byCommand[command] = hg
+	}
+	hg.Counts[i]++
+	hg.Count++
+	hg.Sum += d
+}
+
+// Snapshot returns a copy of the latencies recorded for command.
+func (h *LatencyHistogram) Snapshot(command api.LockCommand) Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hg, ok := h.byCommand[command]
+	if !ok {
+		return Histogram{Bounds: h.bounds, Counts: make([]uint64, len(h.bounds)+1)}
+	}
+	s := *hg
+	s.Counts = append([]uint64(nil), hg.Counts...)
+	return s
+}
+
+// Commands returns the commands for which latencies were recorded.
+func (h *LatencyHistogram) Commands() []api.LockCommand {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	commands := make([]api.LockCommand, 0, len(h.byCommand))
+	for c := range h.byCommand {
+		commands = append(commands, c)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i] < commands[j] })
+	return commands
+}
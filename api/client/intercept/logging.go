@@ -0,0 +1,56 @@
+// Package intercept provides ready-made interceptors of distrilock client requests.
+package intercept
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+)
+
+// Logging returns an interceptor logging each request to logger with its outcome and duration; successful requests are logged at debug
+// level, unsuccessful results at info level and errors at error level.
+func Logging(logger *slog.Logger) client.Interceptor {
+	return func(ctx context.Context, req *api.LockRequest, next client.Do) (*api.LockResponse, error) {
+		start := time.Now()
+		res, err := next(ctx, req)
+		d := time.Since(start)
+
+		attrs := []slog.Attr{
+			slog.String("command", req.Command.String()),
+			slog.String("lock", req.LockName),
+			slog.Uint64("request_id", req.RequestID),
+			slog.Duration("duration", d),
+		}
+		switch {
+		case err != nil:
+			attrs = append(attrs, slog.Any("error", err))
+			logger.LogAttrs(ctx, slog.LevelError, "distrilock request failed", attrs...)
+		case res.Result != api.Success:
+			attrs = append(attrs, slog.String("result", res.Result.String()), slog.String("reason", res.Reason))
+			logger.LogAttrs(ctx, slog.LevelInfo, "distrilock request unsuccessful", attrs...)
+		default:
+			attrs = append(attrs, slog.String("result", res.Result.String()))
+			logger.LogAttrs(ctx, slog.LevelDebug, "distrilock request", attrs...)
+		}
+
+		return res, err
+	}
+}
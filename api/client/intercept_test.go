@@ -0,0 +1,108 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/concurrent"
+	"github.com/gdm85/distrilock/api/client/intercept"
+	"github.com/gdm85/distrilock/api/client/tcp"
+)
+
+func TestInterceptors(t *testing.T) {
+	a, err := net.ResolveTCPAddr("tcp", defaultServerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := concurrent.New(tcp.New(a, time.Second*3, time.Second*2, time.Second*2))
+	defer c.Close()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	h := intercept.NewLatencyHistogram()
+
+	// fault injection, innermost so that failures are logged and measured
+	errInjected := errors.New("injected fault")
+	var order []string
+	fail := func(ctx context.Context, req *api.LockRequest, next client.Do) (*api.LockResponse, error) {
+		order = append(order, "fail")
+		if req.Command == api.Verify {
+			return nil, errInjected
+		}
+		return next(ctx, req)
+	}
+	first := func(ctx context.Context, req *api.LockRequest, next client.Do) (*api.LockResponse, error) {
+		order = append(order, "first")
+		return next(ctx, req)
+	}
+
+	c.(client.Interceptable).SetInterceptors(first, intercept.Logging(logger), h.Intercept, fail)
+
+	lockName := generateLockName(t)
+	l, err := c.Acquire(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(order, ",") != "first,fail" {
+		t.Fatal("unexpected interceptors order", order)
+	}
+	err = l.Verify()
+	if err != errInjected {
+		t.Fatal("expected injected fault, got", err)
+	}
+	err = l.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, command := range []api.LockCommand{api.Acquire, api.Verify, api.Release} {
+		s := h.Snapshot(command)
+		if s.Count != 1 || len(s.Counts) != len(s.Bounds)+1 {
+			t.Fatalf("unexpected %v histogram %+v", command, s)
+		}
+	}
+	if len(h.Commands()) != 3 {
+		t.Fatal("unexpected commands", h.Commands())
+	}
+
+	out := logs.String()
+	for _, expected := range []string{"command=Acquire lock=" + lockName, "level=ERROR", "error=\"injected fault\"", "command=Release"} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected %q in logs:\n%s", expected, out)
+		}
+	}
+
+	// interceptors can be removed
+	order = nil
+	c.(client.Interceptable).SetInterceptors()
+	_, err = c.IsLocked(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 0 {
+		t.Fatal("interceptors were not removed")
+	}
+}
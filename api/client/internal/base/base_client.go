@@ -17,46 +17,259 @@ with this program; if not, write to the Free Software Foundation, Inc.,
 */
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
 	"github.com/gdm85/distrilock/api"
 	"github.com/gdm85/distrilock/api/client"
 )
 
 type clientImpl interface {
-	AcquireConn() error
-	// Do is the function called to process a request on the wire and return the result.
-	Do(req *api.LockRequest) (*api.LockResponse, error)
+	// AcquireConn establishes a connection, if none is established yet; ctx bounds dialing and handshake.
+	AcquireConn(ctx context.Context) error
+	// Do is the function called to process a request on the wire and return the result; it is interrupted when ctx is done.
+	Do(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error)
 	// Close will release and close the underlying connection (if any).
 	Close() error
 }
 
+// connKeeper is implemented by transports which can keep using their connection after a request timed out.
+type connKeeper interface {
+	KeepsConnOnTimeout() bool
+}
+
 type baseClient struct {
 	clientImpl
+
+	// ownerID identifies this client to the daemon across reconnections.
+	ownerID string
+	// lastRequestID is the ID of the last request sent; accessed atomically.
+	lastRequestID uint64
+
+	interceptorsLock sync.Mutex
+	// send is the transport Do wrapped by the interceptors, if any.
+	send client.Do
+
+	heldLock sync.Mutex
+	// held are the locks acquired through this client and not released yet, by name.
+	held map[string]*trackedLock
+	// connDone is closed when the current connection fails or is closed, for transports which do not detect it; guarded by heldLock.
+	connDone chan struct{}
+}
+
+// SetToken sets the token presented by the transport at the start of each new connection; it has no effect on transports which do not support tokens.
+func (c *baseClient) SetToken(token string) {
+	if a, ok := c.clientImpl.(client.Authenticator); ok {
+		a.SetToken(token)
+	}
+}
+
+// SetEventHandler sets the function called with each event pushed by the daemon; it has no effect on transports which do not support push events.
+func (c *baseClient) SetEventHandler(handler func(ev *api.LockResponse)) {
+	if er, ok := c.clientImpl.(client.EventReceiver); ok {
+		er.SetEventHandler(handler)
+	}
+}
+
+// ConnDone returns a channel which is closed when the current connection is interrupted, or nil if no connection is established; for
+// transports which do not detect the interruption themselves, the channel is closed as soon as a request fails or the client is closed.
+func (c *baseClient) ConnDone() <-chan struct{} {
+	if cw, ok := c.clientImpl.(client.ConnWatcher); ok {
+		return cw.ConnDone()
+	}
+	c.heldLock.Lock()
+	defer c.heldLock.Unlock()
+	return c.connDone
+}
+
+// AcquireConn establishes a connection through the transport, if none is established yet; ctx bounds dialing and handshake.
+func (c *baseClient) AcquireConn(ctx context.Context) error {
+	err := c.clientImpl.AcquireConn(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.clientImpl.(client.ConnWatcher); ok {
+		return nil
+	}
+	c.heldLock.Lock()
+	if c.connDone == nil {
+		// a new connection was established
+		c.connDone = make(chan struct{})
+	}
+	c.heldLock.Unlock()
+	return nil
+}
+
+// SetInterceptors sets the interceptors of the requests sent afterwards; the first interceptor is the outermost.
+func (c *baseClient) SetInterceptors(interceptors ...client.Interceptor) {
+	send := client.Do(c.doTransport)
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], send
+		send = func(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error) {
+			return interceptor(ctx, req, next)
+		}
+	}
+
+	c.interceptorsLock.Lock()
+	c.send = send
+	c.interceptorsLock.Unlock()
+}
+
+// sendRequest sends the request through the interceptors, if any.
+func (c *baseClient) sendRequest(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error) {
+	c.interceptorsLock.Lock()
+	send := c.send
+	c.interceptorsLock.Unlock()
+	if send == nil {
+		send = c.doTransport
+	}
+	if err := ctx.Err(); err != nil {
+		// connection is not affected
+		return nil, err
+	}
+	return send(ctx, req)
+}
+
+// doTransport sends the request through the transport; only its errors affect the connection, not the ones returned by interceptors.
+func (c *baseClient) doTransport(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error) {
+	if err := ctx.Err(); err != nil {
+		// connection is not affected
+		return nil, err
+	}
+	res, err := c.clientImpl.Do(ctx, req)
+	if err != nil {
+		c.connFailed()
+	}
+	return res, err
 }
 
 func New(ci clientImpl) client.Client {
+	return NewLabeled(ci, "")
+}
+
+// NewLabeled returns a new client whose random owner ID is prefixed by ownerLabel, if not empty.
+func NewLabeled(ci clientImpl, ownerLabel string) client.Client {
+	ownerID := newOwnerID()
+	if ownerLabel != "" {
+		ownerID = ownerLabel + "-" + ownerID
+	}
 	return &baseClient{
 		clientImpl: ci,
+		ownerID:    ownerID,
+		held:       map[string]*trackedLock{},
 	}
 }
 
-// Acquire will acquire a named lock through the distrilock daemon.
-func (c *baseClient) Acquire(lockName string) (*client.Lock, error) {
-	err := c.AcquireConn()
+// newOwnerID returns a random owner identifier.
+func newOwnerID() string {
+	var b [16]byte
+	_, err := rand.Read(b[:])
 	if err != nil {
-		return nil, err
+		panic(err)
 	}
+	return hex.EncodeToString(b[:])
+}
 
+// newRequest returns a new request for the specified command and lock name.
+func (c *baseClient) newRequest(command api.LockCommand, lockName string) *api.LockRequest {
 	var req api.LockRequest
 	req.VersionMajor, req.VersionMinor = api.VersionMajor, api.VersionMinor
-	req.Command = api.Acquire
+	req.Command = command
 	req.LockName = lockName
+	req.OwnerID = c.ownerID
+	req.RequestID = atomic.AddUint64(&c.lastRequestID, 1)
 
-	res, err := c.Do(&req)
+	return &req
+}
+
+// Hello performs the protocol handshake on a new connection through do, authenticating with token if not empty, and returns the requested
+// features which were granted by the daemon. Daemons predating the handshake reply with BadRequest and thus grant no features.
+func Hello(do func(*api.LockRequest) (*api.LockResponse, error), features api.Features, token string) (api.Features, error) {
+	var req api.LockRequest
+	req.VersionMajor, req.VersionMinor = api.VersionMajor, api.VersionMinor
+	req.Command = api.Hello
+	req.Features = features
+	req.Token = token
+
+	res, err := do(&req)
+	if err != nil {
+		return 0, err
+	}
+
+	switch res.Result {
+	case api.Success:
+	case api.BadRequest:
+		// daemon does not know the command
+		return 0, nil
+	default:
+		return 0, &client.Error{Result: res.Result, Reason: res.Reason}
+	}
+
+	if res.VersionMajor > api.VersionMajor {
+		return 0, &client.Error{Result: api.UnsupportedVersion, Reason: fmt.Sprintf("unsupported daemon protocol version %d.%d", res.VersionMajor, res.VersionMinor)}
+	}
+
+	return res.Features & features, nil
+}
+
+// isTimeout returns true if err is a network timeout.
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// Acquire will acquire a named lock through the distrilock daemon.
+func (c *baseClient) Acquire(lockName string) (*client.Lock, error) {
+	return c.AcquireContext(context.Background(), lockName)
+}
+
+// AcquireContext will acquire a named lock through the distrilock daemon, unless ctx is done first.
+func (c *baseClient) AcquireContext(ctx context.Context, lockName string) (*client.Lock, error) {
+	err := c.AcquireConn(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	req := c.newRequest(api.Acquire, lockName)
+	held := c.heldCount()
+
+	res, err := c.sendRequest(ctx, req)
+	if err != nil {
+		if !isTimeout(err) || isDone(ctx, err) {
+			return nil, err
+		}
+
+		// the outcome of the request is unknown; retry once, the daemon will answer with the original outcome
+		if kc, ok := c.clientImpl.(connKeeper); !ok || !kc.KeepsConnOnTimeout() {
+			// connection cannot be used anymore, and closing it releases the other locks of the session
+			closeErr := c.clientImpl.Close()
+			c.connFailed()
+			if closeErr != nil {
+				return nil, err
+			}
+			if held != 0 {
+				return nil, fmt.Errorf("%w: acquisition timed out and %d locks were released: %w", client.ErrSessionReset, held, err)
+			}
+			// no lock is lost, thus retry on a new connection
+			err = c.AcquireConn(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		res, err = c.sendRequest(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if res.Result == api.Success {
+		c.track(lockName)
+
 		// create lock and return it
 		l := &client.Lock{Client: c, Name: lockName}
 
@@ -68,41 +281,36 @@ func (c *baseClient) Acquire(lockName string) (*client.Lock, error) {
 
 // Release will release a locked name previously acquired in this session.
 func (c *baseClient) Release(l *client.Lock) error {
-	err := c.AcquireConn()
-	if err != nil {
-		return err
-	}
-
-	var req api.LockRequest
-	req.VersionMajor, req.VersionMinor = api.VersionMajor, api.VersionMinor
-	req.Command = api.Release
-	req.LockName = l.Name
+	return c.ReleaseContext(context.Background(), l)
+}
 
-	res, err := c.Do(&req)
+// ReleaseContext will release a locked name previously acquired in this session, unless ctx is done first.
+func (c *baseClient) ReleaseContext(ctx context.Context, l *client.Lock) error {
+	res, err := c.do(ctx, api.Release, l.Name)
 	if err != nil {
 		return err
 	}
 
 	if res.Result == api.Success {
+		c.untrack(l.Name)
 		return nil
 	}
 
-	return &client.Error{Result: res.Result, Reason: res.Reason}
+	err = &client.Error{Result: res.Result, Reason: res.Reason}
+	if client.IsNotHeld(err) {
+		c.untrack(l.Name)
+	}
+	return err
 }
 
 // IsLocked returns true when distrilock deamon estabilished that lock is currently acquired.
 func (c *baseClient) IsLocked(lockName string) (bool, error) {
-	err := c.AcquireConn()
-	if err != nil {
-		return false, err
-	}
-
-	var req api.LockRequest
-	req.VersionMajor, req.VersionMinor = api.VersionMajor, api.VersionMinor
-	req.Command = api.Peek
-	req.LockName = lockName
+	return c.IsLockedContext(context.Background(), lockName)
+}
 
-	res, err := c.Do(&req)
+// IsLockedContext returns true when distrilock deamon estabilished that lock is currently acquired, unless ctx is done first.
+func (c *baseClient) IsLockedContext(ctx context.Context, lockName string) (bool, error) {
+	res, err := c.do(ctx, api.Peek, lockName)
 	if err != nil {
 		return false, err
 	}
@@ -116,17 +324,12 @@ func (c *baseClient) IsLocked(lockName string) (bool, error) {
 
 // Verify will verify that the lock is currently held by the client and healthy.
 func (c *baseClient) Verify(l *client.Lock) error {
-	err := c.AcquireConn()
-	if err != nil {
-		return err
-	}
-
-	var req api.LockRequest
-	req.VersionMajor, req.VersionMinor = api.VersionMajor, api.VersionMinor
-	req.Command = api.Verify
-	req.LockName = l.Name
+	return c.VerifyContext(context.Background(), l)
+}
 
-	res, err := c.Do(&req)
+// VerifyContext will verify that the lock is currently held by the client and healthy, unless ctx is done first.
+func (c *baseClient) VerifyContext(ctx context.Context, l *client.Lock) error {
+	res, err := c.do(ctx, api.Verify, l.Name)
 	if err != nil {
 		return err
 	}
@@ -135,5 +338,19 @@ func (c *baseClient) Verify(l *client.Lock) error {
 		return nil
 	}
 
-	return &client.Error{Result: res.Result, Reason: res.Reason}
+	err = &client.Error{Result: res.Result, Reason: res.Reason}
+	if client.IsNotHeld(err) {
+		c.markLost(l.Name)
+	}
+	return err
+}
+
+// do sends a new request for the specified command and lock name, establishing a connection if necessary.
+func (c *baseClient) do(ctx context.Context, command api.LockCommand, lockName string) (*api.LockResponse, error) {
+	err := c.AcquireConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.sendRequest(ctx, c.newRequest(command, lockName))
 }
@@ -0,0 +1,94 @@
+package bclient
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past, used to interrupt blocked I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Deadline returns the earliest between the deadline of ctx and timeout from now; it is zero if ctx has no deadline and timeout is zero.
+func Deadline(ctx context.Context, timeout time.Duration) time.Time {
+	var t time.Time
+	if timeout != 0 {
+		t = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (t.IsZero() || d.Before(t)) {
+		return d
+	}
+	return t
+}
+
+// SetDeadline sets through setDeadline the earliest between the deadline of ctx and timeout from now; the error of ctx is returned if it is
+// done, as the deadline set meanwhile by Interrupt would have been overwritten.
+func SetDeadline(ctx context.Context, setDeadline func(time.Time) error, timeout time.Duration) error {
+	err := setDeadline(Deadline(ctx, timeout))
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// Interrupt sets a deadline in the past through setDeadline when ctx is done, to interrupt blocked I/O; the returned function must be called
+// when the I/O has completed and returns true if it was interrupted.
+func Interrupt(ctx context.Context, setDeadline func(time.Time) error) (stop func() bool) {
+	if ctx.Done() == nil {
+		// context can never be done
+		return func() bool { return false }
+	}
+
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = setDeadline(aLongTimeAgo)
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+
+	return func() bool {
+		close(done)
+		return <-interrupted
+	}
+}
+
+// ContextError returns the error of ctx if err was caused by ctx being done, otherwise err; since context deadlines are used
+// as connection deadlines, a timeout at or past the deadline of ctx is caused by it.
+func ContextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if isTimeout(err) {
+		if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
+
+// isDone returns true if err was returned because ctx is done.
+func isDone(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || err == context.DeadlineExceeded || err == context.Canceled
+}
@@ -0,0 +1,55 @@
+package bclient
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"net"
+
+	"github.com/gdm85/distrilock/api/client"
+)
+
+// DialContext connects to the address on the named network according to the dial timeout, local address and keep-alive of o.
+func DialContext(ctx context.Context, network, address string, o *client.Options) (net.Conn, error) {
+	d := net.Dialer{
+		Timeout:   o.DialTimeout,
+		LocalAddr: o.LocalAddr,
+	}
+	c, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if o.KeepAlive != 0 {
+		conn, ok := c.(*net.TCPConn)
+		if !ok {
+			// keep-alive does not apply, e.g. to Unix domain sockets
+			return c, nil
+		}
+
+		// setup keep-alive
+		err = conn.SetKeepAlive(true)
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		err = conn.SetKeepAlivePeriod(o.KeepAlive)
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,222 @@
+package bclient
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+)
+
+// trackedLock is a lock acquired through the client and not released yet.
+type trackedLock struct {
+	acquiredAt time.Time
+	lost       bool
+	// connDone is closed when the connection through which the lock was acquired is interrupted.
+	connDone <-chan struct{}
+}
+
+// isLost returns true if the lock was found lost or its connection was interrupted.
+func (t *trackedLock) isLost() bool {
+	if t.lost {
+		return true
+	}
+	select {
+	case <-t.connDone:
+		return true
+	default:
+		return false
+	}
+}
+
+// track records the acquisition of the named lock; re-entrant acquisitions of a lock still held are not recorded.
+func (c *baseClient) track(lockName string) {
+	connDone := c.ConnDone()
+
+	c.heldLock.Lock()
+	defer c.heldLock.Unlock()
+	if t, ok := c.held[lockName]; ok && !t.isLost() {
+		return
+	}
+	c.held[lockName] = &trackedLock{acquiredAt: time.Now(), connDone: connDone}
+}
+
+// untrack forgets the named lock, which is not held anymore.
+func (c *baseClient) untrack(lockName string) {
+	c.heldLock.Lock()
+	delete(c.held, lockName)
+	c.heldLock.Unlock()
+}
+
+// markLost marks the named lock as lost.
+func (c *baseClient) markLost(lockName string) {
+	c.heldLock.Lock()
+	if t, ok := c.held[lockName]; ok {
+		t.lost = true
+	}
+	c.heldLock.Unlock()
+}
+
+// connFailed marks all locks as lost after a transport error or the closing of the connection, unless the transport keeps its connection after errors;
+// such transports detect the interruption of their connection themselves.
+func (c *baseClient) connFailed() {
+	if kc, ok := c.clientImpl.(connKeeper); ok && kc.KeepsConnOnTimeout() {
+		return
+	}
+	c.heldLock.Lock()
+	for _, t := range c.held {
+		t.lost = true
+	}
+	c.closeConnDone()
+	c.heldLock.Unlock()
+}
+
+// closeConnDone signals the end of the current connection, if any; heldLock must be held.
+func (c *baseClient) closeConnDone() {
+	if c.connDone != nil {
+		close(c.connDone)
+		c.connDone = nil
+	}
+}
+
+// isHeld returns true if the named lock is tracked and not found lost.
+func (c *baseClient) isHeld(lockName string) bool {
+	c.heldLock.Lock()
+	defer c.heldLock.Unlock()
+	t, ok := c.held[lockName]
+	return ok && !t.isLost()
+}
+
+// heldCount returns the number of locks held, excluding the ones found lost.
+func (c *baseClient) heldCount() int {
+	c.heldLock.Lock()
+	defer c.heldLock.Unlock()
+	var n int
+	for _, t := range c.held {
+		if !t.isLost() {
+			n++
+		}
+	}
+	return n
+}
+
+// Held returns the locks acquired and not released yet, sorted by name, including the ones found lost.
+func (c *baseClient) Held() []client.HeldLock {
+	c.heldLock.Lock()
+	defer c.heldLock.Unlock()
+
+	held := make([]client.HeldLock, 0, len(c.held))
+	for name, t := range c.held {
+		h := client.HeldLock{Name: name, AcquiredAt: t.acquiredAt, State: client.LockHeld}
+		if t.isLost() {
+			h.State = client.LockLost
+		}
+		held = append(held, h)
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i].Name < held[j].Name })
+	return held
+}
+
+// MySession returns the names of the locks held by the session according to the daemon, and reconciles the tracked locks with them.
+func (c *baseClient) MySession(ctx context.Context) ([]string, error) {
+	res, err := c.do(ctx, api.MySession, "")
+	if err != nil {
+		return nil, err
+	}
+	if res.VersionMajor == 0 && res.VersionMinor < 6 {
+		// earlier daemons reject the command as a request with an invalid lock name
+		return nil, fmt.Errorf("%w: MySession requires protocol version 0.6, daemon has %d.%d", client.ErrUnsupported, res.VersionMajor, res.VersionMinor)
+	}
+	if res.Result != api.Success {
+		return nil, &client.Error{Result: res.Result, Reason: res.Reason}
+	}
+
+	connDone := c.ConnDone()
+	names := map[string]bool{}
+	c.heldLock.Lock()
+	for _, name := range res.LockNames {
+		names[name] = true
+		if t, ok := c.held[name]; !ok || t.isLost() {
+			// acquisition time is unknown
+			c.held[name] = &trackedLock{connDone: connDone}
+		}
+	}
+	for name, t := range c.held {
+		if !names[name] {
+			t.lost = true
+		}
+	}
+	c.heldLock.Unlock()
+
+	return res.LockNames, nil
+}
+
+// CloseWithReport explicitly releases each held lock, then closes the connection; it returns the outcome of each release.
+// Locks found lost, also while releasing the others, are not released and no new connection is established to release them.
+func (c *baseClient) CloseWithReport(ctx context.Context) ([]client.ReleaseReport, error) {
+	held := c.Held()
+	reports := make([]client.ReleaseReport, len(held))
+	for i, h := range held {
+		reports[i].HeldLock = h
+		// a failed release marks the remaining locks lost when the connection cannot be used anymore
+		if !c.isHeld(h.Name) {
+			reports[i].State = client.LockLost
+			continue
+		}
+
+		res, err := c.sendRequest(ctx, c.newRequest(api.Release, h.Name))
+		if err == nil && res.Result != api.Success {
+			err = &client.Error{Result: res.Result, Reason: res.Reason}
+		}
+		if err == nil {
+			continue
+		}
+		if client.IsNotHeld(err) || !c.isHeld(h.Name) {
+			// nothing left to release
+			reports[i].State = client.LockLost
+			continue
+		}
+		reports[i].Err = err
+	}
+
+	c.heldLock.Lock()
+	c.held = map[string]*trackedLock{}
+	c.closeConnDone()
+	c.heldLock.Unlock()
+
+	return reports, c.clientImpl.Close()
+}
+
+// Close explicitly releases each held lock, then closes the connection; the errors of failed releases are returned joined with the
+// error closing the connection. Lost locks are not reported as errors.
+func (c *baseClient) Close() error {
+	reports, err := c.CloseWithReport(context.Background())
+	var errs []error
+	for _, r := range reports {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("release of lock %s: %w", r.Name, r.Err))
+		}
+	}
+	if len(errs) == 0 {
+		return err
+	}
+	return errors.Join(append(errs, err)...)
+}
@@ -16,7 +16,11 @@ with this program; if not, write to the Free Software Foundation, Inc.,
 */
 
 import (
+	"fmt"
+	"sync/atomic"
 	"testing"
+
+	"github.com/gdm85/distrilock/api/client/concurrent"
 )
 
 func BenchmarkLocalLocks(b *testing.B) {
@@ -50,3 +54,43 @@ func BenchmarkLocalLocks(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkParallelLocalLocks(b *testing.B) {
+	lockName := generateLockName(b)
+
+	for _, cs := range clientSuites {
+		if !cs.concurrencySafe {
+			// not covered by this benchmark
+			continue
+		}
+		c := cs.createLocalClient()
+		if cs.clientType != muxClientType {
+			c = concurrent.New(c)
+		}
+
+		b.Run(cs.name, func(b *testing.B) {
+			var n uint64
+			b.RunParallel(func(pb *testing.PB) {
+				name := fmt.Sprintf("%s-%d", lockName, atomic.AddUint64(&n, 1))
+				for pb.Next() {
+					l, err := c.Acquire(name)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					err = l.Release()
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+
+		err := c.Close()
+		if err != nil {
+			b.Error(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package client
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LockContextInterval is the interval at which the locks acquired through WithLock are verified.
+const LockContextInterval = time.Second
+
+// WithLock acquires the named lock through c and returns a context derived from ctx, which is cancelled as soon as the lock is found lost by
+// its monitor, with the loss error as cause, and a function which releases the lock and cancels the context; the lock is verified every
+// LockContextInterval, thus c must be concurrency-safe. If c does not support contexts, ctx is only checked before acquiring.
+func WithLock(ctx context.Context, c Client, lockName string) (context.Context, func() error, error) {
+	l, err := AcquireContext(ctx, c, lockName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lctx, cancel := context.WithCancelCause(ctx)
+	l.Monitor(LockContextInterval, cancel)
+
+	var once sync.Once
+	var releaseErr error
+	release := func() error {
+		once.Do(func() {
+			releaseErr = l.Release()
+			cancel(nil)
+		})
+		return releaseErr
+	}
+	return lctx, release, nil
+}
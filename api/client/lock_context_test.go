@@ -0,0 +1,122 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/concurrent"
+	"github.com/gdm85/distrilock/api/client/mux"
+	"github.com/gdm85/distrilock/api/client/ws"
+)
+
+func TestWithLock(t *testing.T) {
+	for _, cs := range clientSuites {
+		cs := cs
+		if !cs.concurrencySafe {
+			// lock is monitored concurrently
+			continue
+		}
+		t.Run(cs.name, func(t *testing.T) {
+			lockName := generateLockName(t)
+			ctx, release, err := client.WithLock(context.Background(), cs.testClientA1, lockName)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// an acquisition through another session fails
+			_, _, err = client.WithLock(context.Background(), cs.testClientA2, lockName)
+			if !errors.Is(err, client.ErrHeldByOtherSession) {
+				t.Fatal("expected acquisition to fail, got", err)
+			}
+
+			if ctx.Err() != nil {
+				t.Fatal("context cancelled while lock is held")
+			}
+			err = release()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ctx.Err() != context.Canceled {
+				t.Fatal("expected context cancelled after release, got", ctx.Err())
+			}
+			// release is idempotent
+			if err := release(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestWithLockCancelledOnConnectionLoss(t *testing.T) {
+	p := newProxy(t, defaultServerA)
+	defer p.close()
+
+	c := mux.New(p.l.Addr().(*net.TCPAddr), time.Second*3, time.Second*2, time.Second*2)
+	defer c.Close()
+
+	ctx, release, err := client.WithLock(context.Background(), c, generateLockName(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	p.close()
+
+	select {
+	case <-ctx.Done():
+		if context.Cause(ctx) != client.ErrConnLost {
+			t.Fatal("expected ErrConnLost as cause, got", context.Cause(ctx))
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("context not cancelled on connection loss")
+	}
+}
+
+func TestWithLockCancelledOnFailedRequest(t *testing.T) {
+	p := newProxy(t, websocketHostPort(defaultWebsocketServerA))
+	defer p.close()
+
+	c := concurrent.New(ws.NewBinary("ws://"+p.l.Addr().String()+"/distrilock", time.Second*3, time.Second*2, time.Second*2))
+	defer c.Close()
+
+	ctx, release, err := client.WithLock(context.Background(), c, generateLockName(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	// the interruption is detected by the first request failing on the connection
+	p.interrupt()
+	_, err = c.IsLocked(generateLockName(t))
+	if err == nil {
+		t.Fatal("expected request to fail on an interrupted connection")
+	}
+
+	select {
+	case <-ctx.Done():
+		if context.Cause(ctx) != client.ErrConnLost {
+			t.Fatal("expected ErrConnLost as cause, got", context.Cause(ctx))
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("context not cancelled on connection loss")
+	}
+}
@@ -0,0 +1,108 @@
+package client
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrConnLost is reported by the lock monitor when the connection of the session holding the lock is interrupted, which releases the lock.
+var ErrConnLost = errors.New("connection interrupted")
+
+// monitor is the state of the background verification of a lock.
+type monitor struct {
+	// lost receives the error by which the lock was found lost, then it is closed.
+	lost chan error
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Monitor starts verifying the lock in background every interval until it is released through its Release or ReleaseContext short-hands;
+// for clients implementing ConnWatcher the interruption of the connection is detected as soon as the client does, i.e. immediately for
+// pipelined clients and at the first failing request for the others. When the lock is found lost, monitoring stops and onLost, if not nil,
+// is called with the error. Verifications run concurrently with other calls, thus the client must be concurrency-safe. Monitor must be
+// called before sharing the lock with other goroutines; calling it again has no effect.
+func (l *Lock) Monitor(interval time.Duration, onLost func(err error)) {
+	if l.monitor != nil {
+		return
+	}
+	m := &monitor{
+		lost: make(chan error, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	l.monitor = m
+
+	var connDone <-chan struct{}
+	if cw, ok := l.Client.(ConnWatcher); ok {
+		connDone = cw.ConnDone()
+	}
+
+	go func() {
+		err := m.run(l, interval, connDone)
+		if err != nil {
+			m.lost <- err
+		}
+		close(m.lost)
+		close(m.done)
+
+		// called last, so that it can release the lock
+		if err != nil && onLost != nil {
+			onLost(err)
+		}
+	}()
+}
+
+// run verifies the lock every interval and returns the error by which it was found lost, or nil if stopped.
+func (m *monitor) run(l *Lock, interval time.Duration, connDone <-chan struct{}) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return nil
+		case <-connDone:
+			return ErrConnLost
+		case <-t.C:
+			if err := l.Verify(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Lost returns a channel which receives the error by which the monitored lock was found lost; the channel is closed when monitoring
+// stops, and it is nil if the lock is not monitored.
+func (l *Lock) Lost() <-chan error {
+	if l.monitor == nil {
+		return nil
+	}
+	return l.monitor.lost
+}
+
+// stopMonitor stops the lock monitor, if started, and waits for any verification in flight.
+func (l *Lock) stopMonitor() {
+	m := l.monitor
+	if m == nil {
+		return
+	}
+	m.stopOnce.Do(func() { close(m.stop) })
+	<-m.done
+}
@@ -0,0 +1,157 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/concurrent"
+	"github.com/gdm85/distrilock/api/client/mux"
+	"github.com/gdm85/distrilock/api/client/tcp"
+)
+
+func TestMonitorStopsOnRelease(t *testing.T) {
+	for _, cs := range clientSuites {
+		cs := cs
+		if !cs.concurrencySafe {
+			continue
+		}
+		t.Run(cs.name, func(t *testing.T) {
+			l, err := cs.testClientA1.Acquire(generateLockName(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.Monitor(time.Millisecond*10, func(err error) {
+				t.Error("lock reported as lost:", err)
+			})
+
+			// let a few verifications happen
+			time.Sleep(time.Millisecond * 50)
+
+			err = l.Release()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err, ok := <-l.Lost(); ok {
+				t.Fatal("expected channel closed without error, got", err)
+			}
+		})
+	}
+}
+
+func TestMonitorDetectsLostLock(t *testing.T) {
+	for _, cs := range clientSuites {
+		cs := cs
+		if !cs.concurrencySafe {
+			continue
+		}
+		t.Run(cs.name, func(t *testing.T) {
+			c := cs.testClientA1
+			l, err := c.Acquire(generateLockName(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lost := make(chan error, 1)
+			l.Monitor(time.Millisecond*10, func(err error) {
+				lost <- err
+			})
+
+			// release without going through the lock short-hand, so that the monitor is not stopped
+			err = c.Release(l)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case err := <-l.Lost():
+				if !errors.Is(err, client.ErrNotHeld) {
+					t.Fatal("expected ErrNotHeld, got", err)
+				}
+			case <-time.After(time.Second * 2):
+				t.Fatal("lock loss not detected")
+			}
+			if err := <-lost; !errors.Is(err, client.ErrNotHeld) {
+				t.Fatal("expected callback with ErrNotHeld, got", err)
+			}
+		})
+	}
+}
+
+func TestMonitorDetectsConnectionLoss(t *testing.T) {
+	p := newProxy(t, defaultServerA)
+	defer p.close()
+
+	c := mux.New(p.l.Addr().(*net.TCPAddr), time.Second*3, time.Second*2, time.Second*2)
+	defer c.Close()
+
+	l, err := c.Acquire(generateLockName(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// verification would happen too late, thus loss must be detected through the connection
+	l.Monitor(time.Hour, nil)
+
+	p.close()
+
+	select {
+	case err := <-l.Lost():
+		if err != client.ErrConnLost {
+			t.Fatal("expected ErrConnLost, got", err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("connection loss not detected")
+	}
+
+	// monitor has already stopped
+	err = l.Release()
+	if err == nil {
+		t.Fatal("expected release to fail on a closed connection")
+	}
+}
+
+func TestMonitorDetectsFailedRequest(t *testing.T) {
+	p := newProxy(t, defaultServerA)
+	defer p.close()
+
+	c := concurrent.New(tcp.New(p.l.Addr().(*net.TCPAddr), time.Second*3, time.Second*2, time.Second*2))
+	defer c.Close()
+
+	l, err := c.Acquire(generateLockName(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Monitor(time.Hour, nil)
+
+	// the interruption is detected by the first request failing on the connection
+	p.interrupt()
+	_, err = c.IsLocked(generateLockName(t))
+	if err == nil {
+		t.Fatal("expected request to fail on an interrupted connection")
+	}
+
+	select {
+	case err := <-l.Lost():
+		if err != client.ErrConnLost {
+			t.Fatal("expected ErrConnLost, got", err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("connection loss not detected")
+	}
+}
@@ -0,0 +1,73 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/tcp"
+	"github.com/gdm85/distrilock/api/client/ws"
+)
+
+const (
+	// multi-listener daemon, serving all transports with shared locks
+	defaultMultiServer          = ":63431"
+	defaultMultiWebsocketServer = "ws://localhost:63531/distrilock"
+)
+
+func TestMultiListenerSharedLocks(t *testing.T) {
+	a, err := net.ResolveTCPAddr("tcp", defaultMultiServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := map[string]client.Client{
+		"tcp":       tcp.New(a, time.Second*3, time.Second*2, time.Second*2),
+		"websocket": ws.NewBinary(defaultMultiWebsocketServer, time.Second*3, time.Second*2, time.Second*2),
+		"unix":      tcp.NewUnix(unixSocket(t, "m.sock"), time.Second*2, time.Second*2),
+	}
+	for _, c := range clients {
+		defer c.Close()
+	}
+
+	for holderName, holder := range clients {
+		lockName := generateLockName(t)
+
+		l, err := holder.Acquire(lockName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// sessions of other transports share the state of the same process, instead of contending through fcntl
+		for name, c := range clients {
+			if c == holder {
+				continue
+			}
+			_, err = c.Acquire(lockName)
+			if !errors.Is(err, client.ErrHeldByOtherSession) {
+				t.Errorf("%s: expected lock held by the %s session, got %v", name, holderName, err)
+			}
+		}
+
+		err = l.Release()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Package mux provides a concurrency-safe distrilock client over TCP which pipelines requests of many goroutines over a single connection.
+package mux
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/internal/base"
+)
+
+var (
+	// errTimeout is returned when a response was not received within the read timeout.
+	errTimeout = timeoutError{}
+	// errPipeliningUnsupported is returned when the daemon does not grant the pipelining feature.
+	errPipeliningUnsupported = errors.New("daemon does not support pipelining")
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout waiting for response" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// muxClient is a single-connection, concurrency-safe client to a distrilock daemon; responses are matched to requests by their ID.
+type muxClient struct {
+	address string
+	// options are used to establish connections.
+	options client.Options
+
+	readTimeout, writeTimeout time.Duration
+	// token is presented in the handshake of each new connection.
+	token string
+	// eventHandler is called with the events pushed by the daemon, if not nil.
+	eventHandler func(ev *api.LockResponse)
+
+	sync.Mutex
+	s *session
+}
+
+// session is a single connection and its in-flight requests.
+type session struct {
+	conn *net.TCPConn
+
+	writeLock sync.Mutex
+	e         *gob.Encoder
+
+	// eventHandler is called with the events pushed by the daemon, if not nil.
+	eventHandler func(ev *api.LockResponse)
+
+	pendingLock sync.Mutex
+	pending     map[uint64]chan *api.LockResponse
+	// err is the error which interrupted the connection; set before done is closed.
+	err  error
+	done chan struct{}
+}
+
+// String returns a summary of the client connection.
+func (c *muxClient) String() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.s == nil {
+		return fmt.Sprintf("%v", nil)
+	}
+	return fmt.Sprintf("%v", c.s.conn)
+}
+
+// New returns a new concurrency-safe distrilock client; no connection is performed until the client is actually used.
+func New(endpoint *net.TCPAddr, keepAlive, readTimeout, writeTimeout time.Duration) client.Client {
+	return newClient(endpoint.String(), client.Options{KeepAlive: keepAlive, ReadTimeout: readTimeout, WriteTimeout: writeTimeout})
+}
+
+// NewWithOptions returns a new concurrency-safe distrilock client for the TCP address, with client.DefaultOptions changed by opts;
+// TLS is not supported. No connection is performed until the client is actually used.
+func NewWithOptions(address string, opts ...client.Option) client.Client {
+	return newClient(address, client.NewOptions(opts...))
+}
+
+func newClient(address string, o client.Options) client.Client {
+	return bclient.NewLabeled(&muxClient{
+		address:      address,
+		options:      o,
+		token:        o.Token,
+		readTimeout:  o.ReadTimeout,
+		writeTimeout: o.WriteTimeout,
+	}, o.OwnerLabel)
+}
+
+// SetToken sets the token presented at the start of each new connection.
+func (c *muxClient) SetToken(token string) {
+	c.Lock()
+	c.token = token
+	c.Unlock()
+}
+
+// SetEventHandler sets the function called with each event pushed by the daemon on connections established afterwards.
+func (c *muxClient) SetEventHandler(handler func(ev *api.LockResponse)) {
+	c.Lock()
+	c.eventHandler = handler
+	c.Unlock()
+}
+
+// ConnDone returns a channel which is closed when the current connection is interrupted, or nil if no connection is established.
+func (c *muxClient) ConnDone() <-chan struct{} {
+	c.Lock()
+	defer c.Unlock()
+	if c.s == nil {
+		return nil
+	}
+	return c.s.done
+}
+
+// AcquireConn is called every time a connection would be necessary; it does nothing if connection has already been made. It will re-estabilish a connection if the previous one was closed or interrupted.
+func (c *muxClient) AcquireConn(ctx context.Context) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.s != nil {
+		select {
+		case <-c.s.done:
+			// connection was interrupted
+			_ = c.s.conn.Close()
+			c.s = nil
+		default:
+			return nil
+		}
+	}
+
+	nc, err := bclient.DialContext(ctx, "tcp", c.address, &c.options)
+	if err != nil {
+		return bclient.ContextError(ctx, err)
+	}
+	conn := nc.(*net.TCPConn)
+
+	s := &session{
+		conn:         conn,
+		e:            gob.NewEncoder(conn),
+		eventHandler: c.eventHandler,
+		pending:      map[uint64]chan *api.LockResponse{},
+		done:         make(chan struct{}),
+	}
+	features := api.FeaturePipelining
+	if s.eventHandler != nil {
+		features |= api.FeaturePushEvents
+	}
+	d := gob.NewDecoder(conn)
+
+	// handshake happens before any other request is in flight
+	stop := bclient.Interrupt(ctx, conn.SetDeadline)
+	granted, err := bclient.Hello(func(req *api.LockRequest) (*api.LockResponse, error) {
+		err := bclient.SetDeadline(ctx, conn.SetWriteDeadline, c.writeTimeout)
+		if err != nil {
+			return nil, err
+		}
+		err = s.e.Encode(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var res api.LockResponse
+		err = bclient.SetDeadline(ctx, conn.SetReadDeadline, c.readTimeout)
+		if err != nil {
+			return nil, err
+		}
+		err = d.Decode(&res)
+		if err != nil {
+			return nil, err
+		}
+
+		// later requests are subject to their own timeout
+		return &res, conn.SetReadDeadline(time.Time{})
+	}, features, c.token)
+	if stop() && err == nil {
+		// handshake completed, but the connection deadline was set by the interruption
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return bclient.ContextError(ctx, err)
+	}
+	if granted&api.FeaturePipelining == 0 {
+		_ = conn.Close()
+		return errPipeliningUnsupported
+	}
+
+	c.s = s
+	go s.readResponses(d)
+
+	return nil
+}
+
+// write sends the request within timeout, if not zero; a partially written request cannot be recovered and interrupts the connection.
+// The deadline of the request is not used, as the connection is shared with the other requests.
+func (s *session) write(req *api.LockRequest, timeout time.Duration) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	err := s.conn.SetWriteDeadline(bclient.Deadline(context.Background(), timeout))
+	if err == nil {
+		err = s.e.Encode(req)
+	}
+	if err != nil {
+		_ = s.conn.Close()
+	}
+	return err
+}
+
+// readResponses dispatches each response to the request with the same ID and each event to the event handler, until the connection is interrupted.
+func (s *session) readResponses(d *gob.Decoder) {
+	for {
+		var res api.LockResponse
+		err := d.Decode(&res)
+		if err != nil {
+			s.pendingLock.Lock()
+			s.err = err
+			s.pendingLock.Unlock()
+			close(s.done)
+			return
+		}
+
+		if res.Command == api.ShuttingDown {
+			// events are only pushed if an event handler was set
+			if s.eventHandler != nil {
+				s.eventHandler(&res)
+			}
+			continue
+		}
+
+		s.pendingLock.Lock()
+		ch, ok := s.pending[res.RequestID]
+		delete(s.pending, res.RequestID)
+		s.pendingLock.Unlock()
+
+		// responses to requests which timed out are discarded
+		if ok {
+			ch <- &res
+		}
+	}
+}
+
+// Do sends the request and waits for its response; when interrupted by ctx only the request is abandoned, while the connection can be still used.
+// The request is written within the write timeout regardless of ctx, as a partial write would interrupt the connection for all requests.
+func (c *muxClient) Do(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	s := c.s
+	c.Unlock()
+	if s == nil {
+		return nil, errors.New("connection closed")
+	}
+
+	ch := make(chan *api.LockResponse, 1)
+	s.pendingLock.Lock()
+	if s.err != nil {
+		s.pendingLock.Unlock()
+		return nil, s.err
+	}
+	if _, ok := s.pending[req.RequestID]; ok {
+		s.pendingLock.Unlock()
+		return nil, fmt.Errorf("request %d is already in flight", req.RequestID)
+	}
+	s.pending[req.RequestID] = ch
+	s.pendingLock.Unlock()
+
+	err = s.write(req, c.writeTimeout)
+	if err != nil {
+		s.forget(req.RequestID)
+		return nil, bclient.ContextError(ctx, err)
+	}
+
+	// wait for a response
+	var timeout <-chan time.Time
+	if c.readTimeout != 0 {
+		t := time.NewTimer(c.readTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-s.done:
+		s.forget(req.RequestID)
+		return nil, s.err
+	case <-timeout:
+		s.forget(req.RequestID)
+		return nil, errTimeout
+	case <-ctx.Done():
+		s.forget(req.RequestID)
+		return nil, ctx.Err()
+	}
+}
+
+// forget removes the specified request from the in-flight requests.
+func (s *session) forget(requestID uint64) {
+	s.pendingLock.Lock()
+	delete(s.pending, requestID)
+	s.pendingLock.Unlock()
+}
+
+// KeepsConnOnTimeout returns true as a request timeout does not affect other requests on the same connection.
+func (c *muxClient) KeepsConnOnTimeout() bool {
+	return true
+}
+
+func (c *muxClient) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.s == nil {
+		return nil
+	}
+	err := c.s.conn.Close()
+	if err != nil {
+		return err
+	}
+	// wait for all in-flight requests to be interrupted
+	<-c.s.done
+	c.s = nil
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/mux"
+)
+
+func TestConcurrentUse(t *testing.T) {
+	for _, cs := range clientSuites {
+		if !cs.concurrencySafe {
+			continue
+		}
+		cs := cs
+		t.Run(cs.name, func(t *testing.T) {
+			t.Parallel()
+
+			baseName := generateLockName(t)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 32; i++ {
+				wg.Add(1)
+				go func(lockName string) {
+					defer wg.Done()
+
+					l, err := cs.testClientA1.Acquire(lockName)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					err = l.Verify()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					err = l.Release()
+					if err != nil {
+						t.Error(err)
+					}
+				}(fmt.Sprintf("%s-%d", baseName, i))
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestPipelinedResponsesMatchRequests(t *testing.T) {
+	s := newRawSession(t, defaultServerA)
+	defer s.close()
+
+	lockName := generateLockName(t)
+
+	// send all requests before reading any response
+	const n = 16
+	for i := uint64(1); i <= n; i++ {
+		s.send(t, api.LockRequest{Command: api.Peek, LockName: fmt.Sprintf("%s-%d", lockName, i), OwnerID: lockName, RequestID: i})
+	}
+
+	seen := map[uint64]bool{}
+	for i := 0; i < n; i++ {
+		res := s.receive(t)
+		if res.Result != api.Success {
+			t.Fatal("expected Success, got", res.Result, res.Reason)
+		}
+		if res.LockName != fmt.Sprintf("%s-%d", lockName, res.RequestID) {
+			t.Fatal("response for request", res.RequestID, "carries lock name", res.LockName)
+		}
+		if seen[res.RequestID] {
+			t.Fatal("duplicate response for request", res.RequestID)
+		}
+		seen[res.RequestID] = true
+	}
+}
+
+func TestPipelinedDeadlinesKeepConnection(t *testing.T) {
+	a, err := net.ResolveTCPAddr("tcp", defaultServerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := mux.New(a, time.Second*3, time.Second*2, time.Second*2)
+	defer c.Close()
+
+	lockName := generateLockName(t)
+	l, err := c.Acquire(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// requests whose deadline expires while being sent are abandoned, but do not interrupt the shared connection
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 16; j++ {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Microsecond*time.Duration(j))
+				_, _ = client.IsLockedContext(ctx, c, fmt.Sprintf("%s-%d", lockName, i))
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	err = l.Verify()
+	if err != nil {
+		t.Fatal("expected lock still held, got", err)
+	}
+	err = l.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,169 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+)
+
+// lineSession is a newline-delimited JSON session, as it would be used from nc or socat.
+type lineSession struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newLineSession(t *testing.T, address string) *lineSession {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.SetDeadline(time.Now().Add(time.Second * 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &lineSession{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (s *lineSession) do(t *testing.T, line string) api.LockResponse {
+	_, err := fmt.Fprintln(s.conn, line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.r.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res api.LockResponse
+	err = json.Unmarshal(b, &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestNewlineDelimitedJSON(t *testing.T) {
+	lockName := generateLockName(t)
+
+	s := newLineSession(t, defaultServerA)
+	defer func() {
+		_ = s.conn.Close()
+	}()
+
+	res := s.do(t, fmt.Sprintf(`{"Command":%d,"LockName":%q}`, api.Acquire, lockName))
+	if res.Result != api.Success {
+		t.Fatal("expected Success, got", res.Result, res.Reason)
+	}
+
+	// commands can also be specified by name
+	res = s.do(t, fmt.Sprintf(`{"Command":"Peek","LockName":%q}`, lockName))
+	if res.Result != api.Success || !res.IsLocked {
+		t.Fatal("expected Success and lock acquired, got", res.Result, res.Reason, res.IsLocked)
+	}
+
+	// a different session over gob sees the lock acquired by the JSON session
+	gs := newRawSession(t, defaultServerA)
+	defer gs.close()
+	gres := gs.do(t, api.LockRequest{Command: api.Acquire, LockName: lockName})
+	if gres.Result != api.HeldByOtherSession {
+		t.Fatal("expected HeldByOtherSession, got", gres.Result, gres.Reason)
+	}
+
+	res = s.do(t, fmt.Sprintf(`{"Command":"Release","LockName":%q}`, lockName))
+	if res.Result != api.Success {
+		t.Fatal("expected Success, got", res.Result, res.Reason)
+	}
+}
+
+func TestNewlineDelimitedJSONDisconnect(t *testing.T) {
+	lockName := generateLockName(t)
+
+	s := newLineSession(t, defaultServerA)
+	res := s.do(t, fmt.Sprintf(`{"Command":"Acquire","LockName":%q}`, lockName))
+	if res.Result != api.Success {
+		t.Fatal("expected Success, got", res.Result, res.Reason)
+	}
+
+	// session ends with the connection
+	err := s.conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gs := newRawSession(t, defaultServerA)
+	defer gs.close()
+	for i := 0; ; i++ {
+		gres := gs.do(t, api.LockRequest{Command: api.Peek, LockName: lockName})
+		if gres.Result != api.Success {
+			t.Fatal("expected Success, got", gres.Result, gres.Reason)
+		}
+		if !gres.IsLocked {
+			break
+		}
+		// disconnection is processed asynchronously by the daemon
+		if i == 50 {
+			t.Fatal("expected lock to be released on disconnection")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestNewlineDelimitedJSONOrdered(t *testing.T) {
+	lockName := generateLockName(t)
+
+	s := newLineSession(t, defaultServerA)
+	defer func() {
+		_ = s.conn.Close()
+	}()
+
+	// without pipelining, requests written at once are processed and answered in order
+	const pairs = 50
+	var buf []byte
+	for i := 0; i < pairs; i++ {
+		buf = append(buf, fmt.Sprintf("{\"Command\":\"Acquire\",\"LockName\":%q}\n{\"Command\":\"Release\",\"LockName\":%q}\n", lockName, lockName)...)
+	}
+	_, err := s.conn.Write(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < pairs*2; i++ {
+		b, err := s.r.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res api.LockResponse
+		err = json.Unmarshal(b, &res)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := api.Acquire
+		if i%2 == 1 {
+			expected = api.Release
+		}
+		if res.Command != expected || res.Result != api.Success {
+			t.Fatalf("response %d: expected %v Success, got %v %v %s", i, expected, res.Command, res.Result, res.Reason)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package client
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Options are the settings of a client common to all transports; settings which do not apply to a transport are ignored.
+type Options struct {
+	// KeepAlive is the TCP keep-alive period, or 0 for the system default.
+	KeepAlive time.Duration
+	// ReadTimeout bounds the wait for each response, or 0 for no timeout.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the sending of each request, or 0 for no timeout.
+	WriteTimeout time.Duration
+	// DialTimeout bounds the connection establishment, websocket handshake included, or 0 for no timeout other than the context.
+	DialTimeout time.Duration
+	// LocalAddr is the local address to connect from, if not nil.
+	LocalAddr net.Addr
+	// TLSConfig enables TLS for TCP clients and configures it for TCP and secure websocket clients.
+	TLSConfig *tls.Config
+	// Header is sent in the websocket handshake.
+	Header http.Header
+	// Proxy returns the proxy of the websocket connection, if not nil; otherwise the proxy is taken from the environment.
+	Proxy func(*http.Request) (*url.URL, error)
+	// OwnerLabel prefixes the random owner ID of the client, to identify it in the daemon.
+	OwnerLabel string
+	// Token is presented in the handshake of each new connection.
+	Token string
+}
+
+// DefaultOptions are the options of clients before any Option is applied.
+var DefaultOptions = Options{
+	KeepAlive:    time.Second * 3,
+	ReadTimeout:  time.Second * 3,
+	WriteTimeout: time.Second * 3,
+	DialTimeout:  time.Second * 10,
+}
+
+// Option changes an option of a client.
+type Option func(o *Options)
+
+// NewOptions returns DefaultOptions changed by opts.
+func NewOptions(opts ...Option) Options {
+	o := DefaultOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithKeepAlive sets the TCP keep-alive period.
+func WithKeepAlive(keepAlive time.Duration) Option {
+	return func(o *Options) { o.KeepAlive = keepAlive }
+}
+
+// WithReadTimeout sets the timeout of the wait for each response.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(o *Options) { o.ReadTimeout = timeout }
+}
+
+// WithWriteTimeout sets the timeout of the sending of each request.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(o *Options) { o.WriteTimeout = timeout }
+}
+
+// WithDialTimeout sets the timeout of the connection establishment.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(o *Options) { o.DialTimeout = timeout }
+}
+
+// WithLocalAddr sets the local address to connect from.
+func WithLocalAddr(addr net.Addr) Option {
+	return func(o *Options) { o.LocalAddr = addr }
+}
+
+// WithTLSConfig sets the TLS configuration; client certificates for mutual TLS can be specified in it.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(o *Options) { o.TLSConfig = tlsConfig }
+}
+
+// WithHeader sets the header sent in the websocket handshake.
+func WithHeader(header http.Header) Option {
+	return func(o *Options) { o.Header = header }
+}
+
+// WithProxy sets the function returning the proxy of websocket connections.
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) Option {
+	return func(o *Options) { o.Proxy = proxy }
+}
+
+// WithOwnerLabel sets the label prefixing the owner ID of the client.
+func WithOwnerLabel(label string) Option {
+	return func(o *Options) { o.OwnerLabel = label }
+}
+
+// WithToken sets the token presented in the handshake of each new connection.
+func WithToken(token string) Option {
+	return func(o *Options) { o.Token = token }
+}
+
+// Connector is implemented by clients which can establish their connection before the first request.
+type Connector interface {
+	// AcquireConn establishes a connection, if none is established yet; ctx bounds dialing and handshake.
+	AcquireConn(ctx context.Context) error
+}
+
+// SchemeFunc returns a new client for the endpoint URL with the specified options.
+type SchemeFunc func(u *url.URL, o Options) (Client, error)
+
+var (
+	schemes     = map[string]SchemeFunc{}
+	schemesLock sync.RWMutex
+)
+
+// RegisterScheme makes the clients of a transport available to Dial for the URL scheme; transport packages register their schemes when
+// imported, e.g. package tcp registers "tcp" and "unix", package ws registers "ws" and "wss". It panics if the scheme is already registered.
+func RegisterScheme(scheme string, newClient SchemeFunc) {
+	schemesLock.Lock()
+	defer schemesLock.Unlock()
+	if _, ok := schemes[scheme]; ok {
+		panic("BUG: scheme registered twice: " + scheme)
+	}
+	schemes[scheme] = newClient
+}
+
+// Dial returns a new client for the endpoint URL, e.g. "tcp://host:40800", "unix:///run/distrilock.sock" or "ws://host:40801", with
+// DefaultOptions changed by opts; the package of the transport must be imported. The connection is established before returning,
+// unless ctx is done first.
+func Dial(ctx context.Context, rawURL string, opts ...Option) (Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	schemesLock.RLock()
+	newClient, ok := schemes[u.Scheme]
+	schemesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown client URL scheme %q", u.Scheme)
+	}
+
+	c, err := newClient(u, NewOptions(opts...))
+	if err != nil {
+		return nil, err
+	}
+
+	if cn, ok := c.(Connector); ok {
+		err = cn.AcquireConn(ctx)
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,353 @@
+// Package pool provides a concurrency-safe distrilock client which spreads the requests of many goroutines over a pool of connections.
+package pool
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+)
+
+// errClosed is returned when using a pool after Close.
+var errClosed = errors.New("pool closed")
+
+// member is a client of the pool, with its own connection and session.
+type member struct {
+	c client.Client
+	// busy is true while a request is in progress.
+	busy bool
+	// held is the number of locks acquired and not yet released through the member.
+	held     int
+	lastUsed time.Time
+}
+
+// pinnedLock is a lock acquired through a member, which must be released and verified through the same member.
+type pinnedLock struct {
+	m *member
+	l *client.Lock
+}
+
+type poolClient struct {
+	newClient   func() client.Client
+	maxConns    int
+	idleTimeout time.Duration
+
+	mu      sync.Mutex
+	members []*member
+	locks   map[*client.Lock]pinnedLock
+	// names are the members holding each lock name, so that acquisitions of a held lock go to the same session.
+	names map[string]*member
+	// changed is closed and replaced whenever a member becomes available.
+	changed chan struct{}
+	token   string
+	closed  bool
+	done    chan struct{}
+}
+
+// New returns a concurrency-safe client keeping up to maxConns connections of the clients returned by newClient, e.g. tcp.New;
+// each acquired lock is pinned to the connection holding it, thus releases and verifications go to the same session.
+// Connections are added when all are busy with other requests and closed after being unused for idleTimeout while holding
+// no locks; if idleTimeout is zero they are kept until Close.
+func New(newClient func() client.Client, maxConns int, idleTimeout time.Duration) client.Client {
+	if maxConns < 1 {
+		panic("BUG: pool must have at least one connection")
+	}
+	p := &poolClient{
+		newClient:   newClient,
+		maxConns:    maxConns,
+		idleTimeout: idleTimeout,
+		locks:       map[*client.Lock]pinnedLock{},
+		names:       map[string]*member{},
+		changed:     make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if idleTimeout != 0 {
+		go p.shrink()
+	}
+	return p
+}
+
+// String returns a summary of the pool connections.
+func (p *poolClient) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return fmt.Sprintf("pool of %d/%d connections", len(p.members), p.maxConns)
+}
+
+// SetToken sets the token presented at the start of each new connection, if supported by the pooled clients.
+func (p *poolClient) SetToken(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.token = token
+	for _, m := range p.members {
+		if a, ok := m.c.(client.Authenticator); ok {
+			a.SetToken(token)
+		}
+	}
+}
+
+// get reserves the pinned member, or else an available member; it waits until the member is available or ctx is done.
+func (p *poolClient) get(ctx context.Context, pinned *member) (*member, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, errClosed
+		}
+		m := pinned
+		if m == nil {
+			m = p.availableMember()
+		}
+		if m != nil && !m.busy {
+			m.busy = true
+			p.mu.Unlock()
+			return m, nil
+		}
+
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		p.mu.Lock()
+	}
+}
+
+// availableMember returns the most recently used member which is not busy, so that the others can become idle, or else a
+// new member if the pool is not full. It must be called with mu held.
+func (p *poolClient) availableMember() *member {
+	var best *member
+	for _, m := range p.members {
+		if !m.busy && (best == nil || m.lastUsed.After(best.lastUsed)) {
+			best = m
+		}
+	}
+	if best != nil || len(p.members) == p.maxConns {
+		return best
+	}
+
+	// no connection is performed until the client is used
+	c := p.newClient()
+	if a, ok := c.(client.Authenticator); ok && p.token != "" {
+		a.SetToken(p.token)
+	}
+	m := &member{c: c}
+	p.members = append(p.members, m)
+	return m
+}
+
+// put makes the member available again; it must be called with mu held.
+func (p *poolClient) put(m *member) {
+	m.busy = false
+	m.lastUsed = time.Now()
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// shrink periodically closes the members which were not used for the idle timeout and hold no locks, until the pool is closed.
+func (p *poolClient) shrink() {
+	t := time.NewTicker(p.idleTimeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-t.C:
+			var idle []*member
+			p.mu.Lock()
+			members := make([]*member, 0, len(p.members))
+			for _, m := range p.members {
+				if !m.busy && m.held == 0 && now.Sub(m.lastUsed) >= p.idleTimeout {
+					idle = append(idle, m)
+					continue
+				}
+				members = append(members, m)
+			}
+			p.members = members
+			p.mu.Unlock()
+
+			for _, m := range idle {
+				_ = m.c.Close()
+			}
+		}
+	}
+}
+
+// pinned returns the lock acquired through a member for l; locks not acquired through the pool are pinned to the member
+// holding a lock with the same name, if any.
+func (p *poolClient) pinned(l *client.Lock) pinnedLock {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pl, ok := p.locks[l]
+	if !ok {
+		pl.m = p.names[l.Name]
+	}
+	return pl
+}
+
+// unpin forgets the lock after it was released; it must be called with mu held.
+func (p *poolClient) unpin(l *client.Lock, pl pinnedLock) {
+	if _, ok := p.locks[l]; !ok {
+		return
+	}
+	delete(p.locks, l)
+	pl.m.held--
+	if p.names[l.Name] == pl.m {
+		delete(p.names, l.Name)
+	}
+}
+
+// Acquire will acquire a named lock through the distrilock daemon.
+func (p *poolClient) Acquire(lockName string) (*client.Lock, error) {
+	return p.AcquireContext(context.Background(), lockName)
+}
+
+// AcquireContext will acquire a named lock through the distrilock daemon, unless ctx is done first; a lock already held
+// through the pool is acquired again through the same session.
+func (p *poolClient) AcquireContext(ctx context.Context, lockName string) (*client.Lock, error) {
+	p.mu.Lock()
+	pinned := p.names[lockName]
+	p.mu.Unlock()
+
+	m, err := p.get(ctx, pinned)
+	if err != nil {
+		return nil, err
+	}
+	ml, err := client.AcquireContext(ctx, m.c, lockName)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.put(m)
+	if err != nil {
+		return nil, err
+	}
+
+	l := client.NewLock(p, lockName)
+	p.locks[l] = pinnedLock{m: m, l: ml}
+	p.names[lockName] = m
+	m.held++
+	return l, nil
+}
+
+// Release will release a locked name previously acquired through the pool.
+func (p *poolClient) Release(l *client.Lock) error {
+	return p.ReleaseContext(context.Background(), l)
+}
+
+// ReleaseContext will release a locked name previously acquired through the pool, unless ctx is done first.
+func (p *poolClient) ReleaseContext(ctx context.Context, l *client.Lock) error {
+	pl := p.pinned(l)
+	m, err := p.get(ctx, pl.m)
+	if err != nil {
+		return err
+	}
+	ml := pl.l
+	if ml == nil {
+		ml = &client.Lock{Client: m.c, Name: l.Name}
+	}
+	err = client.ReleaseContext(ctx, m.c, ml)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.put(m)
+	if err == nil || client.IsNotHeld(err) {
+		p.unpin(l, pl)
+	}
+	return err
+}
+
+// IsLocked returns true when distrilock deamon estabilished that lock is currently acquired.
+func (p *poolClient) IsLocked(lockName string) (bool, error) {
+	return p.IsLockedContext(context.Background(), lockName)
+}
+
+// IsLockedContext returns true when distrilock deamon estabilished that lock is currently acquired, unless ctx is done first.
+func (p *poolClient) IsLockedContext(ctx context.Context, lockName string) (bool, error) {
+	m, err := p.get(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	b, err := client.IsLockedContext(ctx, m.c, lockName)
+
+	p.mu.Lock()
+	p.put(m)
+	p.mu.Unlock()
+	return b, err
+}
+
+// Verify will verify that the lock is currently held by the session of the pool which acquired it and healthy.
+func (p *poolClient) Verify(l *client.Lock) error {
+	return p.VerifyContext(context.Background(), l)
+}
+
+// VerifyContext will verify that the lock is currently held by the session of the pool which acquired it and healthy, unless ctx is done first.
+func (p *poolClient) VerifyContext(ctx context.Context, l *client.Lock) error {
+	pl := p.pinned(l)
+	m, err := p.get(ctx, pl.m)
+	if err != nil {
+		return err
+	}
+	ml := pl.l
+	if ml == nil {
+		ml = &client.Lock{Client: m.c, Name: l.Name}
+	}
+	err = client.VerifyContext(ctx, m.c, ml)
+
+	p.mu.Lock()
+	p.put(m)
+	p.mu.Unlock()
+	return err
+}
+
+// Close closes all connections, thus releasing all locks acquired through the pool; the first error is returned.
+func (p *poolClient) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	members := p.members
+	p.members = nil
+	p.locks = map[*client.Lock]pinnedLock{}
+	p.names = map[string]*member{}
+	// wake up requests waiting for a member
+	close(p.changed)
+	p.changed = make(chan struct{})
+	p.mu.Unlock()
+
+	var firstErr error
+	for _, m := range members {
+		err := m.c.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,99 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/pool"
+	"github.com/gdm85/distrilock/api/client/tcp"
+)
+
+func TestPoolPinsLocksToSessions(t *testing.T) {
+	a, err := net.ResolveTCPAddr("tcp", defaultServerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const idleTimeout = time.Millisecond * 200
+	p := pool.New(func() client.Client {
+		return tcp.New(a, time.Second*3, time.Second*2, time.Second*2)
+	}, 4, idleTimeout)
+	defer p.Close()
+
+	baseName := generateLockName(t)
+
+	// concurrent acquisitions are spread over multiple sessions
+	const n = 16
+	locks := make([]*client.Lock, n)
+	var wg sync.WaitGroup
+	for i := range locks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l, err := p.Acquire(fmt.Sprintf("%s-%d", baseName, i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			locks[i] = l
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	// connections holding locks are not closed when idle
+	time.Sleep(idleTimeout * 2)
+	for _, l := range locks {
+		err = l.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// acquiring a held lock again goes to the session holding it
+	l, err := p.Acquire(locks[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = locks[0].Release()
+	if !errors.Is(err, client.ErrNotHeld) {
+		t.Fatal("expected lock not held, got", err)
+	}
+	for _, l := range locks[1:] {
+		err = l.Release()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// connections without locks are closed when idle
+	time.Sleep(idleTimeout * 2)
+	if s := fmt.Sprint(p); s != "pool of 0/4 connections" {
+		t.Fatal("expected idle connections to be closed, got", s)
+	}
+}
@@ -0,0 +1,355 @@
+// Package quorum provides a distrilock client which holds each lock on a majority of independent daemons.
+package quorum
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+)
+
+var (
+	// ErrNoQuorum is matched by the errors returned when a command did not succeed on enough deployments.
+	ErrNoQuorum = errors.New("no quorum")
+	errClosed   = errors.New("client closed")
+)
+
+// Error is returned when a command did not succeed on enough deployments: a majority, or all deployments holding the lock for releases.
+type Error struct {
+	// Succeeded is the number of deployments where the command succeeded, out of Total.
+	Succeeded, Total int
+	// Errs are the errors of the deployments where the command failed.
+	Errs []error
+}
+
+// Error returns a summary of the errors of all deployments.
+func (e *Error) Error() string {
+	errs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		errs[i] = err.Error()
+	}
+	return fmt.Sprintf("%v: succeeded on %d/%d deployments: %s", ErrNoQuorum, e.Succeeded, e.Total, strings.Join(errs, "; "))
+}
+
+// Is returns true for ErrNoQuorum and for the errors matched by any of the deployment errors, e.g. client.ErrHeldByOtherSession.
+func (e *Error) Is(target error) bool {
+	if target == ErrNoQuorum {
+		return true
+	}
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// deployment is the client of an independent deployment.
+type deployment struct {
+	// Mutex serialises the requests to the deployment.
+	sync.Mutex
+	c client.Client
+}
+
+type quorumClient struct {
+	deployments []*deployment
+	budget      time.Duration
+
+	mu sync.Mutex
+	// locks are the locks acquired on each deployment, by lock acquired on the quorum.
+	locks  map[*client.Lock][]*client.Lock
+	closed bool
+}
+
+// New returns a concurrency-safe client holding each lock on a majority of the clients, each connected to an independent deployment
+// with its own directory; a lock is acquired when a majority of acquisitions succeeded within budget, else the partial acquisitions
+// are released. Requests to all deployments are sent concurrently. Note that a request which does not complete within budget is
+// cancelled, which closes the connection of clients which are not pipelined and thus releases their locks on that deployment.
+func New(clients []client.Client, budget time.Duration) client.Client {
+	if len(clients) == 0 {
+		panic("BUG: no clients specified")
+	}
+	q := &quorumClient{
+		budget: budget,
+		locks:  map[*client.Lock][]*client.Lock{},
+	}
+	for _, c := range clients {
+		q.deployments = append(q.deployments, &deployment{c: c})
+	}
+	return q
+}
+
+// String returns a summary of the deployments.
+func (q *quorumClient) String() string {
+	return fmt.Sprintf("quorum of %d/%d deployments", q.majority(), len(q.deployments))
+}
+
+func (q *quorumClient) majority() int {
+	return len(q.deployments)/2 + 1
+}
+
+// SetToken sets the token presented at the start of each new connection, if supported by the clients of the deployments.
+func (q *quorumClient) SetToken(token string) {
+	for _, d := range q.deployments {
+		if a, ok := d.c.(client.Authenticator); ok {
+			d.Lock()
+			a.SetToken(token)
+			d.Unlock()
+		}
+	}
+}
+
+// each runs fn concurrently for the client of each deployment where filter is true, or all if nil, and returns their errors;
+// ctx is bounded by the budget.
+func (q *quorumClient) each(ctx context.Context, filter []bool, fn func(ctx context.Context, i int, c client.Client) error) []error {
+	ctx, cancel := context.WithTimeout(ctx, q.budget)
+	defer cancel()
+
+	errs := make([]error, len(q.deployments))
+	var wg sync.WaitGroup
+	for i, d := range q.deployments {
+		if filter != nil && !filter[i] {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, d *deployment) {
+			defer wg.Done()
+			d.Lock()
+			errs[i] = fn(ctx, i, d.c)
+			d.Unlock()
+		}(i, d)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// result returns nil if the command succeeded on a majority of the deployments where it was run, else an *Error.
+func (q *quorumClient) result(filter []bool, errs []error) error {
+	e := Error{Total: len(q.deployments)}
+	for i, err := range errs {
+		if filter != nil && !filter[i] {
+			continue
+		}
+		if err != nil {
+			e.Errs = append(e.Errs, err)
+			continue
+		}
+		e.Succeeded++
+	}
+	if e.Succeeded >= q.majority() {
+		return nil
+	}
+	return &e
+}
+
+func (q *quorumClient) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// Acquire will acquire a named lock on a majority of the deployments.
+func (q *quorumClient) Acquire(lockName string) (*client.Lock, error) {
+	return q.AcquireContext(context.Background(), lockName)
+}
+
+// AcquireContext will acquire a named lock on a majority of the deployments within the budget, unless ctx is done first;
+// partial acquisitions are released on failure.
+func (q *quorumClient) AcquireContext(ctx context.Context, lockName string) (*client.Lock, error) {
+	if q.isClosed() {
+		return nil, errClosed
+	}
+
+	parts := make([]*client.Lock, len(q.deployments))
+	errs := q.each(ctx, nil, func(ctx context.Context, i int, c client.Client) error {
+		var err error
+		parts[i], err = client.AcquireContext(ctx, c, lockName)
+		return err
+	})
+
+	err := q.result(nil, errs)
+	if err == nil {
+		l := client.NewLock(q, lockName)
+		q.mu.Lock()
+		q.locks[l] = parts
+		q.mu.Unlock()
+		return l, nil
+	}
+
+	// release partial acquisitions, also when ctx is done
+	q.each(context.Background(), acquired(parts), func(ctx context.Context, i int, c client.Client) error {
+		return client.ReleaseContext(ctx, c, parts[i])
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return nil, err
+}
+
+// acquired returns which of the parts were acquired.
+func acquired(parts []*client.Lock) []bool {
+	filter := make([]bool, len(parts))
+	for i, l := range parts {
+		filter[i] = l != nil
+	}
+	return filter
+}
+
+// parts returns the locks acquired on each deployment for l; locks not acquired through this client are assumed to be acquired on all deployments.
+func (q *quorumClient) parts(l *client.Lock) []*client.Lock {
+	q.mu.Lock()
+	parts, ok := q.locks[l]
+	q.mu.Unlock()
+	if ok {
+		return parts
+	}
+
+	parts = make([]*client.Lock, len(q.deployments))
+	for i, d := range q.deployments {
+		parts[i] = &client.Lock{Client: d.c, Name: l.Name}
+	}
+	return parts
+}
+
+// Release will release a locked name previously acquired through this client.
+func (q *quorumClient) Release(l *client.Lock) error {
+	return q.ReleaseContext(context.Background(), l)
+}
+
+// ReleaseContext will release a locked name on all deployments where it was acquired, unless ctx is done first; locks not held
+// anymore, e.g. lost with their session, count as released. On failure the lock is kept for the deployments where it could not be
+// released, so that the release can be retried.
+func (q *quorumClient) ReleaseContext(ctx context.Context, l *client.Lock) error {
+	parts := q.parts(l)
+	filter := acquired(parts)
+	errs := q.each(ctx, filter, func(ctx context.Context, i int, c client.Client) error {
+		err := client.ReleaseContext(ctx, c, parts[i])
+		if errors.Is(err, client.ErrNotHeld) {
+			return nil
+		}
+		return err
+	})
+
+	e := Error{Total: len(q.deployments)}
+	left := make([]*client.Lock, len(parts))
+	for i, err := range errs {
+		if !filter[i] {
+			continue
+		}
+		if err != nil {
+			e.Errs = append(e.Errs, err)
+			left[i] = parts[i]
+			continue
+		}
+		e.Succeeded++
+	}
+
+	q.mu.Lock()
+	if len(e.Errs) == 0 {
+		delete(q.locks, l)
+	} else if _, ok := q.locks[l]; ok {
+		q.locks[l] = left
+	}
+	q.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(e.Errs) != 0 {
+		return &e
+	}
+	return nil
+}
+
+// IsLocked returns true when a majority of the deployments report the lock as acquired.
+func (q *quorumClient) IsLocked(lockName string) (bool, error) {
+	return q.IsLockedContext(context.Background(), lockName)
+}
+
+// IsLockedContext returns true when a majority of the deployments report the lock as acquired, unless ctx is done first;
+// an error is returned if a majority did not answer.
+func (q *quorumClient) IsLockedContext(ctx context.Context, lockName string) (bool, error) {
+	locked := make([]bool, len(q.deployments))
+	errs := q.each(ctx, nil, func(ctx context.Context, i int, c client.Client) error {
+		var err error
+		locked[i], err = client.IsLockedContext(ctx, c, lockName)
+		return err
+	})
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	err := q.result(nil, errs)
+	if err != nil {
+		return false, err
+	}
+
+	n := 0
+	for _, b := range locked {
+		if b {
+			n++
+		}
+	}
+	return n >= q.majority(), nil
+}
+
+// Verify will verify that the lock is currently held on a majority of the deployments.
+func (q *quorumClient) Verify(l *client.Lock) error {
+	return q.VerifyContext(context.Background(), l)
+}
+
+// VerifyContext will verify that the lock is currently held on a majority of the deployments, unless ctx is done first.
+func (q *quorumClient) VerifyContext(ctx context.Context, l *client.Lock) error {
+	parts := q.parts(l)
+	errs := q.each(ctx, acquired(parts), func(ctx context.Context, i int, c client.Client) error {
+		return client.VerifyContext(ctx, c, parts[i])
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// deployments where the lock was not acquired count as failed
+	for i, part := range parts {
+		if part == nil {
+			errs[i] = fmt.Errorf("lock %s not acquired on deployment %d", l.Name, i)
+		}
+	}
+	return q.result(nil, errs)
+}
+
+// Close closes the clients of all deployments, thus releasing all locks; the first error is returned.
+func (q *quorumClient) Close() error {
+	q.mu.Lock()
+	q.closed = true
+	q.locks = map[*client.Lock][]*client.Lock{}
+	q.mu.Unlock()
+
+	var firstErr error
+	for _, d := range q.deployments {
+		d.Lock()
+		err := d.c.Close()
+		d.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,217 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/quorum"
+	"github.com/gdm85/distrilock/api/client/tcp"
+	"github.com/gdm85/distrilock/api/server"
+)
+
+// deployments are local daemons, each with its own temporary directory.
+type deployments struct {
+	addrs   []*net.TCPAddr
+	servers []*server.Server
+}
+
+func startDeployments(t *testing.T, n int) *deployments {
+	var d deployments
+	for i := 0; i < n; i++ {
+		dir, err := ioutil.TempDir("", "distrilock-quorum")
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := &server.Server{Directory: dir}
+		go func() {
+			_ = srv.Serve(l)
+		}()
+		d.addrs = append(d.addrs, l.Addr().(*net.TCPAddr))
+		d.servers = append(d.servers, srv)
+	}
+	return &d
+}
+
+// stop shuts down the i-th daemon, interrupting its sessions.
+func (d *deployments) stop(i int) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	_ = d.servers[i].Shutdown(ctx)
+	cancel()
+}
+
+func (d *deployments) close() {
+	for i, srv := range d.servers {
+		d.stop(i)
+		_ = os.RemoveAll(srv.Directory)
+	}
+}
+
+func (d *deployments) client(i int) client.Client {
+	return tcp.New(d.addrs[i], time.Second*3, time.Second*2, time.Second*2)
+}
+
+// quorumClient returns a quorum client of all deployments.
+func (d *deployments) quorumClient(budget time.Duration) client.Client {
+	var clients []client.Client
+	for i := range d.addrs {
+		clients = append(clients, d.client(i))
+	}
+	return quorum.New(clients, budget)
+}
+
+func TestQuorumAcquireAndRelease(t *testing.T) {
+	d := startDeployments(t, 3)
+	defer d.close()
+	c1, c2 := d.quorumClient(time.Second), d.quorumClient(time.Second)
+	defer c1.Close()
+	defer c2.Close()
+
+	lockName := generateLockName(t)
+	l, err := c1.Acquire(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, err := c2.IsLocked(lockName)
+	if err != nil || !locked {
+		t.Fatal("expected lock acquired, got", locked, err)
+	}
+	_, err = c2.Acquire(lockName)
+	if !errors.Is(err, quorum.ErrNoQuorum) || !errors.Is(err, client.ErrHeldByOtherSession) {
+		t.Fatal("expected no quorum, got", err)
+	}
+
+	err = l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = c2.Acquire(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuorumSurvivesMinorityOutage(t *testing.T) {
+	d := startDeployments(t, 3)
+	defer d.close()
+	c1, c2 := d.quorumClient(time.Second), d.quorumClient(time.Second)
+	defer c1.Close()
+	defer c2.Close()
+
+	lockName := generateLockName(t)
+	l, err := c1.Acquire(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.stop(0)
+	err = l.Verify()
+	if err != nil {
+		t.Fatal("expected lock held on a majority, got", err)
+	}
+	_, err = c2.Acquire(lockName)
+	if !errors.Is(err, quorum.ErrNoQuorum) {
+		t.Fatal("expected no quorum, got", err)
+	}
+
+	d.stop(1)
+	err = l.Verify()
+	if !errors.Is(err, quorum.ErrNoQuorum) {
+		t.Fatal("expected lock lost on a majority, got", err)
+	}
+}
+
+func TestQuorumReleasesPartialAcquisitions(t *testing.T) {
+	d := startDeployments(t, 3)
+	defer d.close()
+	c := d.quorumClient(time.Second)
+	defer c.Close()
+
+	// the lock is held on a majority by other sessions
+	lockName := generateLockName(t)
+	for i := 0; i < 2; i++ {
+		other := d.client(i)
+		defer other.Close()
+		_, err := other.Acquire(lockName)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := c.Acquire(lockName)
+	if !errors.Is(err, quorum.ErrNoQuorum) {
+		t.Fatal("expected no quorum, got", err)
+	}
+
+	// acquisition on the third deployment was released
+	third := d.client(2)
+	defer third.Close()
+	locked, err := third.IsLocked(lockName)
+	if err != nil || locked {
+		t.Fatal("expected partial acquisition to be released, got", locked, err)
+	}
+}
+
+func TestQuorumBudget(t *testing.T) {
+	d := startDeployments(t, 2)
+	defer d.close()
+
+	// a deployment which never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c := quorum.New([]client.Client{
+		d.client(0), d.client(1),
+		tcp.New(l.Addr().(*net.TCPAddr), time.Second*3, time.Second*15, time.Second*15),
+	}, time.Millisecond*200)
+	defer c.Close()
+
+	start := time.Now()
+	lock, err := c.Acquire(generateLockName(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Fatal("budget not respected, acquisition returned after", elapsed)
+	}
+	err = lock.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
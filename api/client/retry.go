@@ -0,0 +1,146 @@
+package client
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+)
+
+// RetryPolicy specifies how AcquireWithRetry retries an acquisition; the zero value retries forever with DefaultRetryPolicy backoffs.
+type RetryPolicy struct {
+	// InitialBackoff is the wait after the first failed attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts.
+	MaxBackoff time.Duration
+	// Multiplier is the growth factor of the wait after each failed attempt; values lower than 1 mean 2.
+	Multiplier float64
+	// Jitter is the fraction, between 0 and 1, by which each wait is randomly shortened so that contending clients do not retry in lockstep.
+	Jitter float64
+	// MaxAttempts is the maximum number of attempts, or 0 for no limit.
+	MaxAttempts int
+	// Timeout bounds the time spent in all attempts and waits, or 0 for no limit other than the context.
+	Timeout time.Duration
+	// AbortOnTransportError stops retrying on connection errors; otherwise the client reconnects at the next attempt.
+	AbortOnTransportError bool
+}
+
+// DefaultRetryPolicy retries with waits growing from 50ms to 5s, shortened by up to 20%, for at most 30s.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialBackoff: time.Millisecond * 50,
+	MaxBackoff:     time.Second * 5,
+	Multiplier:     2,
+	Jitter:         0.2,
+	Timeout:        time.Second * 30,
+}
+
+// RetryStats reports the attempts performed by AcquireWithRetry.
+type RetryStats struct {
+	// Attempts is the number of acquisition requests sent.
+	Attempts int
+	// Contended is the number of attempts which found the lock held by another session or process.
+	Contended int
+	// TransportErrors is the number of attempts which failed because of the connection, each followed by a reconnection.
+	TransportErrors int
+	// Elapsed is the total time spent.
+	Elapsed time.Duration
+}
+
+// AcquireWithRetry acquires the named lock through c, retrying with exponential backoff while it is held by another session or process,
+// and on connection errors unless policy.AbortOnTransportError is set; other errors, e.g. BadRequest or InternalError, are returned
+// immediately. The returned stats are valid also when an error is returned, which is the error of the last attempt or the context error.
+func AcquireWithRetry(ctx context.Context, c Client, lockName string, policy RetryPolicy) (l *Lock, stats RetryStats, err error) {
+	start := time.Now()
+	defer func() {
+		stats.Elapsed = time.Since(start)
+	}()
+	if policy.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.Timeout)
+		defer cancel()
+	}
+
+	backoff := policy.InitialBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryPolicy.InitialBackoff
+	}
+	for {
+		stats.Attempts++
+		l, err = AcquireContext(ctx, c, lockName)
+		if err == nil {
+			return l, stats, nil
+		}
+		if ctx.Err() != nil {
+			return nil, stats, err
+		}
+		var ce *Error
+		if errors.As(err, &ce) {
+			if !isContention(ce) {
+				return nil, stats, err
+			}
+			stats.Contended++
+		} else {
+			stats.TransportErrors++
+			if policy.AbortOnTransportError {
+				return nil, stats, err
+			}
+		}
+		if policy.MaxAttempts != 0 && stats.Attempts >= policy.MaxAttempts {
+			return nil, stats, err
+		}
+
+		wait := backoff
+		if policy.Jitter > 0 {
+			wait -= time.Duration(rand.Float64() * policy.Jitter * float64(wait))
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, stats, ctx.Err()
+		}
+
+		backoff = nextBackoff(backoff, policy)
+	}
+}
+
+// isContention returns true if the acquisition failed because the lock is held elsewhere; daemons before protocol version 0.5
+// report contention as Failed.
+func isContention(e *Error) bool {
+	return e.Result == api.Failed || errors.Is(e, ErrHeldByOtherSession) || errors.Is(e, ErrHeldByOtherProcess)
+}
+
+// nextBackoff returns the wait following backoff according to policy.
+func nextBackoff(backoff time.Duration, policy RetryPolicy) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	next := time.Duration(float64(backoff) * multiplier)
+	if next > maxBackoff || next <= 0 {
+		return maxBackoff
+	}
+	return next
+}
@@ -0,0 +1,170 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/tcp"
+	"github.com/gdm85/distrilock/api/client/ws"
+)
+
+func TestAcquireWithRetryWaitsForRelease(t *testing.T) {
+	for _, cs := range clientSuites {
+		cs := cs
+		t.Run(cs.name, func(t *testing.T) {
+			lockName := generateLockName(t)
+			hl, err := cs.testClientA2.Acquire(lockName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			go func() {
+				time.Sleep(time.Millisecond * 100)
+				_ = hl.Release()
+			}()
+
+			policy := client.RetryPolicy{InitialBackoff: time.Millisecond * 10, MaxBackoff: time.Millisecond * 20, Jitter: 0.5, Timeout: time.Second * 5}
+			l, stats, err := client.AcquireWithRetry(context.Background(), cs.testClientA1, lockName, policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Release()
+
+			// contention is not mistaken for transport errors
+			if stats.Attempts < 2 || stats.Contended != stats.Attempts-1 || stats.TransportErrors != 0 {
+				t.Fatalf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestAcquireWithRetryMaxAttempts(t *testing.T) {
+	for _, cs := range clientSuites {
+		cs := cs
+		t.Run(cs.name, func(t *testing.T) {
+			lockName := generateLockName(t)
+			hl, err := cs.testClientA2.Acquire(lockName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer hl.Release()
+
+			policy := client.RetryPolicy{InitialBackoff: time.Millisecond, MaxAttempts: 3}
+			_, stats, err := client.AcquireWithRetry(context.Background(), cs.testClientA1, lockName, policy)
+			if !errors.Is(err, client.ErrHeldByOtherSession) {
+				t.Fatal("expected ErrHeldByOtherSession, got", err)
+			}
+			if stats.Attempts != 3 || stats.Contended != 3 || stats.TransportErrors != 0 {
+				t.Fatalf("unexpected stats %+v", stats)
+			}
+
+			// timeout stops retrying before the maximum number of attempts
+			policy = client.RetryPolicy{InitialBackoff: time.Millisecond * 20, Timeout: time.Millisecond * 100}
+			_, stats, err = client.AcquireWithRetry(context.Background(), cs.testClientA1, lockName, policy)
+			if err != context.DeadlineExceeded {
+				t.Fatal("expected deadline exceeded, got", err)
+			}
+			if stats.Attempts < 2 || stats.Elapsed < policy.Timeout {
+				t.Fatalf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestAcquireWithRetryAbortsOnBadRequest(t *testing.T) {
+	for _, cs := range clientSuites {
+		cs := cs
+		t.Run(cs.name, func(t *testing.T) {
+			_, stats, err := client.AcquireWithRetry(context.Background(), cs.testClientA1, "invalid/name", client.DefaultRetryPolicy)
+			if !errors.Is(err, client.ErrInvalidLockName) {
+				t.Fatal("expected ErrInvalidLockName, got", err)
+			}
+			if stats.Attempts != 1 {
+				t.Fatalf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestAcquireWithRetryTransportErrors(t *testing.T) {
+	// an address where no daemon is listening
+	dl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := dl.Addr().(*net.TCPAddr)
+	_ = dl.Close()
+	c := tcp.New(a, time.Second*3, time.Second*2, time.Second*2)
+	defer c.Close()
+
+	policy := client.RetryPolicy{InitialBackoff: time.Millisecond, MaxAttempts: 3}
+	_, stats, err := client.AcquireWithRetry(context.Background(), c, generateLockName(t), policy)
+	if err == nil || stats.Attempts != 3 || stats.TransportErrors != 3 {
+		t.Fatalf("unexpected error %v with stats %+v", err, stats)
+	}
+
+	policy.AbortOnTransportError = true
+	_, stats, err = client.AcquireWithRetry(context.Background(), c, generateLockName(t), policy)
+	if err == nil || stats.Attempts != 1 {
+		t.Fatalf("unexpected error %v with stats %+v", err, stats)
+	}
+}
+
+func TestAcquireWithRetryReconnects(t *testing.T) {
+	for name, newClient := range map[string]func(a *net.TCPAddr) client.Client{
+		"tcp": func(a *net.TCPAddr) client.Client {
+			return tcp.New(a, time.Second*3, time.Second*2, time.Second*2)
+		},
+		"wire": func(a *net.TCPAddr) client.Client {
+			return tcp.NewWire(a, time.Second*3, time.Second*2, time.Second*2)
+		},
+		"ws binary": func(a *net.TCPAddr) client.Client {
+			return ws.NewBinary("ws://"+a.String()+"/distrilock", time.Second*3, time.Second*2, time.Second*2)
+		},
+	} {
+		target := defaultServerA
+		if name == "ws binary" {
+			target = websocketHostPort(defaultWebsocketServerA)
+		}
+		p := newProxy(t, target)
+		c := newClient(p.l.Addr().(*net.TCPAddr))
+
+		_, err := c.IsLocked(generateLockName(t))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		// daemon is restarted on the same address
+		p.interrupt()
+
+		policy := client.RetryPolicy{InitialBackoff: time.Millisecond, MaxAttempts: 3}
+		l, stats, err := client.AcquireWithRetry(context.Background(), c, generateLockName(t), policy)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v with stats %+v", name, err, stats)
+		}
+		if stats.Attempts != 2 || stats.TransportErrors != 1 {
+			t.Errorf("%s: unexpected stats %+v", name, stats)
+		}
+		_ = l.Release()
+		_ = c.Close()
+		p.close()
+	}
+}
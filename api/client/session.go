@@ -0,0 +1,72 @@
+package client
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"time"
+)
+
+// LockState is the state of a lock acquired by a client, as known by the client.
+type LockState int
+
+const (
+	// LockHeld is the state of a lock acquired and not released.
+	LockHeld LockState = iota + 1
+	// LockLost is the state of a lock found not held anymore, e.g. because the connection was interrupted or Verify failed.
+	LockLost
+)
+
+// String returns the name of the lock state.
+func (s LockState) String() string {
+	switch s {
+	case LockHeld:
+		return "held"
+	case LockLost:
+		return "lost"
+	}
+	return "unknown"
+}
+
+// HeldLock describes a lock acquired by a client and not released yet.
+type HeldLock struct {
+	Name string
+	// AcquiredAt is the time of the acquisition; it is zero for locks found held by the daemon but unknown to the client, e.g. because the
+	// acquisition response was lost.
+	AcquiredAt time.Time
+	State      LockState
+}
+
+// ReleaseReport is the outcome of the release of a lock when closing a client.
+type ReleaseReport struct {
+	HeldLock
+	// Err is the error of the release, if it failed; lost locks, also the ones found lost while closing, are not released and
+	// have no error.
+	Err error
+}
+
+// SessionTracker is implemented by clients which track the locks held by their session.
+type SessionTracker interface {
+	// Held returns the locks acquired and not released yet, sorted by name, including the ones found lost.
+	Held() []HeldLock
+	// MySession returns the names of the locks held by the session according to the daemon, and reconciles the tracked locks with them:
+	// the locks which are not held are marked lost, while the ones which were not tracked are tracked as held.
+	// Daemons before protocol version 0.6 do not support it and an error matching ErrUnsupported is returned.
+	MySession(ctx context.Context) ([]string, error)
+	// CloseWithReport explicitly releases each held lock, then closes the connection; it returns the outcome of each release.
+	// No new connection is established to release locks.
+	CloseWithReport(ctx context.Context) ([]ReleaseReport, error)
+}
@@ -0,0 +1,291 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/mux"
+	"github.com/gdm85/distrilock/api/client/tcp"
+)
+
+func TestMySession(t *testing.T) {
+	for _, cs := range clientSuites {
+		cs := cs
+		st, ok := cs.testClientA1.(client.SessionTracker)
+		if !ok {
+			continue
+		}
+		t.Run(cs.name, func(t *testing.T) {
+			lockName := generateLockName(t)
+
+			l, err := cs.testClientA1.Acquire(lockName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names, err := st.MySession(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !contains(names, lockName) {
+				t.Fatal("expected lock in session, got", names)
+			}
+			err = l.Release()
+			if err != nil {
+				t.Fatal(err)
+			}
+			names, err = st.MySession(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contains(names, lockName) {
+				t.Fatal("expected lock not in session, got", names)
+			}
+		})
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHeldLocks(t *testing.T) {
+	a, err := net.ResolveTCPAddr("tcp", defaultServerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := tcp.New(a, time.Second*3, time.Second*2, time.Second*2)
+	defer c.Close()
+	st := c.(client.SessionTracker)
+
+	lockName := generateLockName(t)
+	start := time.Now()
+	l1, err := c.Acquire(lockName + "-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Acquire(lockName + "-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	held := st.Held()
+	if len(held) != 2 || held[0].Name != lockName+"-1" || held[1].Name != lockName+"-2" {
+		t.Fatal("unexpected held locks", held)
+	}
+	for _, h := range held {
+		if h.State != client.LockHeld || h.AcquiredAt.Before(start) {
+			t.Fatal("unexpected held lock", h)
+		}
+	}
+
+	err = l1.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held := st.Held(); len(held) != 1 || held[0].Name != lockName+"-2" {
+		t.Fatal("unexpected held locks", held)
+	}
+
+	// closing releases explicitly each lock
+	reports, err := st.CloseWithReport(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Name != lockName+"-2" || reports[0].Err != nil {
+		t.Fatal("unexpected release reports", reports)
+	}
+	if held := st.Held(); len(held) != 0 {
+		t.Fatal("unexpected held locks after close", held)
+	}
+}
+
+func TestReconcileSession(t *testing.T) {
+	a, err := net.ResolveTCPAddr("tcp", defaultServerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := mux.New(a, time.Second*3, time.Second*2, time.Second*2)
+	defer c.Close()
+	st := c.(client.SessionTracker)
+
+	// responses are lost after the daemon processed the requests
+	errLost := errors.New("response lost")
+	lose := false
+	c.(client.Interceptable).SetInterceptors(func(ctx context.Context, req *api.LockRequest, next client.Do) (*api.LockResponse, error) {
+		res, err := next(ctx, req)
+		if err == nil && lose {
+			return nil, errLost
+		}
+		return res, err
+	})
+
+	lockName := generateLockName(t)
+	l, err := c.Acquire(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lose = true
+	_, err = c.Acquire(lockName + "-untracked")
+	if err != errLost {
+		t.Fatal("expected lost response, got", err)
+	}
+	err = l.Release()
+	if err != errLost {
+		t.Fatal("expected lost response, got", err)
+	}
+	lose = false
+
+	held := st.Held()
+	if len(held) != 1 || held[0].Name != lockName || held[0].State != client.LockHeld {
+		t.Fatal("unexpected held locks before reconciliation", held)
+	}
+
+	names, err := st.MySession(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != lockName+"-untracked" {
+		t.Fatal("unexpected session locks", names)
+	}
+
+	held = st.Held()
+	if len(held) != 2 {
+		t.Fatal("unexpected held locks after reconciliation", held)
+	}
+	if held[0].Name != lockName || held[0].State != client.LockLost {
+		t.Fatal("expected released lock to be lost, got", held[0])
+	}
+	if held[1].Name != lockName+"-untracked" || held[1].State != client.LockHeld || !held[1].AcquiredAt.IsZero() {
+		t.Fatal("expected untracked lock to be held, got", held[1])
+	}
+}
+
+func TestInterceptorErrorsKeepLocks(t *testing.T) {
+	a, err := net.ResolveTCPAddr("tcp", defaultServerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := tcp.New(a, time.Second*3, time.Second*2, time.Second*2)
+	defer c.Close()
+	st := c.(client.SessionTracker)
+
+	lockName := generateLockName(t)
+	_, err = c.Acquire(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// faults injected by an interceptor do not reach the connection
+	errInjected := errors.New("injected fault")
+	c.(client.Interceptable).SetInterceptors(func(ctx context.Context, req *api.LockRequest, next client.Do) (*api.LockResponse, error) {
+		return nil, errInjected
+	})
+	_, err = c.IsLocked(lockName)
+	if err != errInjected {
+		t.Fatal("expected injected fault, got", err)
+	}
+	c.(client.Interceptable).SetInterceptors()
+
+	held := st.Held()
+	if len(held) != 1 || held[0].Name != lockName || held[0].State != client.LockHeld {
+		t.Fatal("unexpected held locks", held)
+	}
+	reports, err := st.CloseWithReport(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].State != client.LockHeld || reports[0].Err != nil {
+		t.Fatal("unexpected release reports", reports)
+	}
+}
+
+func TestCloseAfterConnectionLoss(t *testing.T) {
+	for name, newClient := range map[string]func(a *net.TCPAddr) client.Client{
+		"tcp": func(a *net.TCPAddr) client.Client {
+			return tcp.New(a, time.Second*3, time.Second*2, time.Second*2)
+		},
+		"pipelined": func(a *net.TCPAddr) client.Client {
+			return mux.New(a, time.Second*3, time.Second*2, time.Second*2)
+		},
+	} {
+		p := newProxy(t, defaultServerA)
+		c := newClient(p.l.Addr().(*net.TCPAddr))
+
+		lockName := generateLockName(t)
+		for _, suffix := range []string{"-a", "-b", "-c"} {
+			_, err := c.Acquire(lockName + suffix)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+
+		// daemon goes away, which releases the locks of the session
+		p.interrupt()
+
+		reports, err := c.(client.SessionTracker).CloseWithReport(context.Background())
+		if err != nil {
+			t.Errorf("%s: expected close without error, got %v", name, err)
+		}
+		if len(reports) != 3 {
+			t.Errorf("%s: unexpected release reports %v", name, reports)
+		}
+		for _, r := range reports {
+			if r.State != client.LockLost || r.Err != nil {
+				t.Errorf("%s: expected lock reported lost, got %+v", name, r)
+			}
+		}
+		if n := p.accepted(); n != 1 {
+			t.Errorf("%s: expected no new connection while closing, got %d connections", name, n)
+		}
+		p.close()
+	}
+}
+
+func TestMySessionUnsupported(t *testing.T) {
+	a, err := net.ResolveTCPAddr("tcp", defaultServerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := mux.New(a, time.Second*3, time.Second*2, time.Second*2)
+	defer c.Close()
+
+	// answer as a daemon of protocol version 0.5 would
+	c.(client.Interceptable).SetInterceptors(func(ctx context.Context, req *api.LockRequest, next client.Do) (*api.LockResponse, error) {
+		if req.Command != api.MySession {
+			return next(ctx, req)
+		}
+		res := &api.LockResponse{LockRequest: *req, Result: api.InvalidLockName, Reason: "invalid lock name"}
+		res.VersionMajor, res.VersionMinor = 0, 5
+		return res, nil
+	})
+
+	_, err = c.(client.SessionTracker).MySession(context.Background())
+	if !errors.Is(err, client.ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported, got", err)
+	}
+}
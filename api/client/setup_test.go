@@ -27,6 +27,8 @@ import (
 
 	"github.com/gdm85/distrilock/api/client"
 	"github.com/gdm85/distrilock/api/client/concurrent"
+	"github.com/gdm85/distrilock/api/client/mux"
+	"github.com/gdm85/distrilock/api/client/pool"
 	"github.com/gdm85/distrilock/api/client/tcp"
 	"github.com/gdm85/distrilock/api/client/ws"
 
@@ -45,6 +47,13 @@ const (
 	defaultWebsocketServerB = "ws://localhost:63520/distrilock"
 	defaultWebsocketServerC = "ws://localhost:63521/distrilock"
 
+	// muxClientType is the client type of pipelined TCP clients, which are concurrency-safe by design
+	muxClientType = -1
+	// wireClientType is the client type of TCP clients using the binary wire format
+	wireClientType = -2
+	// poolClientType is the client type of pooled TCP clients, which are concurrency-safe by design
+	poolClientType = -3
+
 	deterministicTests = true
 	// copied from process.go
 	lockExt = ".lck"
@@ -103,6 +112,7 @@ func TestMain(m *testing.M) {
 	clientSuites = []*clientSuite{
 		newClientSuite(0, false), newClientSuite(websocket.BinaryMessage, false), newClientSuite(websocket.TextMessage, false),
 		newClientSuite(0, true), newClientSuite(websocket.BinaryMessage, true), newClientSuite(websocket.TextMessage, true),
+		newClientSuite(muxClientType, true), newClientSuite(wireClientType, false), newClientSuite(poolClientType, true),
 	}
 
 	retCode := m.Run()
@@ -123,6 +133,12 @@ func newClientSuite(clientType int, concurrencySafe bool) *clientSuite {
 		cs.name = "Websockets binary clients suite"
 	case websocket.TextMessage:
 		cs.name = "Websockets text clients suite"
+	case muxClientType:
+		cs.name = "TCP pipelined clients suite"
+	case wireClientType:
+		cs.name = "TCP binary wire clients suite"
+	case poolClientType:
+		cs.name = "TCP pooled clients suite"
 	default:
 		cs.name = "TCP clients suite"
 	}
@@ -131,7 +147,7 @@ func newClientSuite(clientType int, concurrencySafe bool) *clientSuite {
 		cs.name += " concurrency-safe"
 	}
 
-	if clientType == 0 {
+	if clientType == 0 || clientType == muxClientType || clientType == wireClientType || clientType == poolClientType {
 		// first server process
 		var err error
 		cs.testLocalAddr, err = net.ResolveTCPAddr("tcp", defaultServerA)
@@ -161,7 +177,7 @@ func newClientSuite(clientType int, concurrencySafe bool) *clientSuite {
 		cs.testClientD1 = cs.createNFSRemoteClient()
 	}
 
-	if concurrencySafe {
+	if concurrencySafe && clientType != muxClientType && clientType != poolClientType {
 		cs.testClientA1 = concurrent.New(cs.testClientA1)
 		cs.testClientA2 = concurrent.New(cs.testClientA2)
 		cs.testClientB1 = concurrent.New(cs.testClientB1)
@@ -181,7 +197,7 @@ func (cs *clientSuite) createSlowNFSLocalClient() client.Client {
 	case websocket.TextMessage:
 		return ws.NewJSON(defaultWebsocketServerC, time.Second*3, time.Second*15, time.Second*15)
 	}
-	return createTCPSlowClient(cs.testNFSLocalAddr)
+	return cs.createTCPSlowClient(cs.testNFSLocalAddr)
 }
 
 func (cs *clientSuite) createNFSRemoteClient() client.Client {
@@ -191,7 +207,7 @@ func (cs *clientSuite) createNFSRemoteClient() client.Client {
 	case websocket.TextMessage:
 		return ws.NewJSON(defaultWebsocketServerD, time.Second*3, time.Second*15, time.Second*15)
 	}
-	return createTCPClient(cs.testNFSRemoteAddr)
+	return cs.createTCPClient(cs.testNFSRemoteAddr)
 }
 
 func (cs *clientSuite) createLocalClient() client.Client {
@@ -201,7 +217,7 @@ func (cs *clientSuite) createLocalClient() client.Client {
 	case websocket.TextMessage:
 		return ws.NewJSON(defaultWebsocketServerA, time.Second*3, time.Second*2, time.Second*15)
 	}
-	return createTCPClient(cs.testLocalAddr)
+	return cs.createTCPClient(cs.testLocalAddr)
 }
 
 func (cs *clientSuite) createLocalAltClient() client.Client {
@@ -217,14 +233,34 @@ func (cs *clientSuite) createLocalAltClient() client.Client {
 		panic(err)
 	}
 
-	return createTCPClient(b)
+	return cs.createTCPClient(b)
 }
 
-func createTCPClient(a *net.TCPAddr) client.Client {
+func (cs *clientSuite) createTCPClient(a *net.TCPAddr) client.Client {
+	switch cs.clientType {
+	case muxClientType:
+		return mux.New(a, time.Second*3, time.Second*2, time.Second*2)
+	case wireClientType:
+		return tcp.NewWire(a, time.Second*3, time.Second*2, time.Second*2)
+	case poolClientType:
+		return pool.New(func() client.Client {
+			return tcp.New(a, time.Second*3, time.Second*2, time.Second*2)
+		}, 4, time.Second*10)
+	}
 	return tcp.New(a, time.Second*3, time.Second*2, time.Second*2)
 }
 
-func createTCPSlowClient(a *net.TCPAddr) client.Client {
+func (cs *clientSuite) createTCPSlowClient(a *net.TCPAddr) client.Client {
+	switch cs.clientType {
+	case muxClientType:
+		return mux.New(a, time.Second*3, time.Second*15, time.Second*15)
+	case wireClientType:
+		return tcp.NewWire(a, time.Second*3, time.Second*15, time.Second*15)
+	case poolClientType:
+		return pool.New(func() client.Client {
+			return tcp.New(a, time.Second*3, time.Second*15, time.Second*15)
+		}, 4, time.Second*10)
+	}
 	return tcp.New(a, time.Second*3, time.Second*15, time.Second*15)
 }
 
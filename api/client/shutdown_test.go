@@ -0,0 +1,120 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/mux"
+	"github.com/gdm85/distrilock/api/client/ws"
+)
+
+const (
+	// multi-listener daemon shut down by TestShutdownReleasesLocks
+	defaultShutdownServer          = ":63432"
+	defaultShutdownWebsocketServer = "ws://localhost:63532/distrilock"
+	// shutdownDrain is the drain period of the daemon
+	shutdownDrain = time.Second * 2
+)
+
+// lockFiles returns the lock files in dir.
+func lockFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.lck"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestShutdownReleasesLocks(t *testing.T) {
+	dir := os.Getenv("SHUTDOWN_LOCK_DIR")
+	if dir == "" {
+		t.Skip("no daemon to shut down specified")
+	}
+	pid, err := strconv.Atoi(os.Getenv("SHUTDOWN_DAEMON_PID"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if syscall.Kill(pid, 0) != nil {
+		t.Skip("daemon was already shut down")
+	}
+
+	a, err := net.ResolveTCPAddr("tcp", defaultShutdownServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan *api.LockResponse, 1)
+	c := mux.New(a, time.Second*3, time.Second*2, time.Second*2)
+	defer c.Close()
+	c.(client.EventReceiver).SetEventHandler(func(ev *api.LockResponse) {
+		events <- ev
+	})
+	// a websocket client without push events holds its lock until the end of the drain period
+	wc := ws.NewBinary(defaultShutdownWebsocketServer, time.Second*3, time.Second*2, time.Second*2)
+	defer wc.Close()
+
+	l, err := c.Acquire(generateLockName(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wc.Acquire(generateLockName(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(lockFiles(t, dir)); n != 2 {
+		t.Fatalf("expected 2 lock files, found %d", n)
+	}
+
+	err = syscall.Kill(pid, syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Command != api.ShuttingDown {
+			t.Fatal("expected shutting down event, got", ev.Command)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("shutting down event not received")
+	}
+
+	// sessions can still release their locks while draining
+	err = l.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(shutdownDrain + time.Second*2)
+	for syscall.Kill(pid, 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("daemon did not exit after the drain period")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	if files := lockFiles(t, dir); len(files) != 0 {
+		t.Fatal("lock files left behind:", files)
+	}
+}
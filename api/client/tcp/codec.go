@@ -0,0 +1,74 @@
+package tcp
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/wire"
+)
+
+// codec encodes the requests and decodes the responses of a session.
+type codec interface {
+	Encode(req *api.LockRequest) error
+	Decode(res *api.LockResponse) error
+}
+
+// newCodecFunc returns the codec for a new connection.
+type newCodecFunc func(conn net.Conn) (codec, error)
+
+type gobCodec struct {
+	d *gob.Decoder
+	e *gob.Encoder
+}
+
+func newGobCodec(conn net.Conn) (codec, error) {
+	return gobCodec{d: gob.NewDecoder(conn), e: gob.NewEncoder(conn)}, nil
+}
+
+func (c gobCodec) Encode(req *api.LockRequest) error {
+	return c.e.Encode(req)
+}
+
+func (c gobCodec) Decode(res *api.LockResponse) error {
+	return c.d.Decode(res)
+}
+
+type wireCodec struct {
+	r io.Reader
+	w io.Writer
+}
+
+// newWireCodec sends the binary wire format preamble and returns the codec.
+func newWireCodec(conn net.Conn) (codec, error) {
+	_, err := io.WriteString(conn, wire.Magic)
+	if err != nil {
+		return nil, err
+	}
+	return wireCodec{r: bufio.NewReader(conn), w: conn}, nil
+}
+
+func (c wireCodec) Encode(req *api.LockRequest) error {
+	return wire.WriteRequest(c.w, req)
+}
+
+func (c wireCodec) Decode(res *api.LockResponse) error {
+	return wire.ReadResponse(c.r, res)
+}
@@ -17,9 +17,11 @@ with this program; if not, write to the Free Software Foundation, Inc.,
 */
 
 import (
-	"encoding/gob"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"net/url"
 	"time"
 
 	"github.com/gdm85/distrilock/api"
@@ -29,76 +31,190 @@ import (
 
 // Client is a single-connection, non-concurrency-safe client to a distrilock daemon.
 type tcpClient struct {
-	endpoint *net.TCPAddr
-	conn     *net.TCPConn
-	d        *gob.Decoder
-	e        *gob.Encoder
+	dial     dialFunc
+	conn     net.Conn
+	newCodec newCodecFunc
+	codec    codec
+	// token is presented in the handshake of each new connection.
+	token string
 
-	keepAlive, readTimeout, writeTimeout time.Duration
+	readTimeout, writeTimeout time.Duration
 }
 
+// dialFunc establishes a new connection to the daemon, unless ctx is done first.
+type dialFunc func(ctx context.Context) (net.Conn, error)
+
 // String returns a summary of the client connection and active locks.
 func (c *tcpClient) String() string {
 	return fmt.Sprintf("%v", c.conn)
 }
 
+func init() {
+	client.RegisterScheme("tcp", newFromURL)
+	client.RegisterScheme("unix", newFromURL)
+}
+
+// newFromURL returns a new client for a tcp://host:port or unix:///path URL; the binary wire format is used with query format=wire.
+func newFromURL(u *url.URL, o client.Options) (client.Client, error) {
+	newCodec := newGobCodec
+	switch format := u.Query().Get("format"); format {
+	case "", "gob":
+	case "wire":
+		newCodec = newWireCodec
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	address := u.Host
+	if u.Scheme == "unix" {
+		address = u.Path
+	}
+	return newClient(u.Scheme, address, o, newCodec), nil
+}
+
 // New returns a new distrilock client; no connection is performed until the client is actually used.
 func New(endpoint *net.TCPAddr, keepAlive, readTimeout, writeTimeout time.Duration) client.Client {
-	return bclient.New(&tcpClient{
-		endpoint:     endpoint,
-		keepAlive:    keepAlive,
-		readTimeout:  readTimeout,
-		writeTimeout: writeTimeout,
-	})
+	return newClient("tcp", endpoint.String(), client.Options{KeepAlive: keepAlive, ReadTimeout: readTimeout, WriteTimeout: writeTimeout}, newGobCodec)
+}
+
+// NewWire returns a new distrilock client using the language-neutral binary wire format; no connection is performed until the client is actually used.
+func NewWire(endpoint *net.TCPAddr, keepAlive, readTimeout, writeTimeout time.Duration) client.Client {
+	return newClient("tcp", endpoint.String(), client.Options{KeepAlive: keepAlive, ReadTimeout: readTimeout, WriteTimeout: writeTimeout}, newWireCodec)
+}
+
+// NewUnix returns a new distrilock client connecting to the Unix domain socket at path; no connection is performed until the client is actually used.
+// Locks are released as soon as the client process terminates, without relying on TCP keep-alive.
+func NewUnix(path string, readTimeout, writeTimeout time.Duration) client.Client {
+	return newClient("unix", path, client.Options{ReadTimeout: readTimeout, WriteTimeout: writeTimeout}, newGobCodec)
+}
+
+// NewTLS returns a new distrilock client connecting with TLS; no connection is performed until the client is actually used.
+// Keep-alive is set on the underlying TCP connection; client certificates for mutual TLS can be specified in tlsConfig.
+func NewTLS(endpoint *net.TCPAddr, tlsConfig *tls.Config, keepAlive, readTimeout, writeTimeout time.Duration) client.Client {
+	return newClient("tcp", endpoint.String(), client.Options{TLSConfig: tlsConfig, KeepAlive: keepAlive, ReadTimeout: readTimeout, WriteTimeout: writeTimeout}, newGobCodec)
+}
+
+// NewWithOptions returns a new distrilock client connecting to address on network "tcp" or "unix", with client.DefaultOptions changed by opts;
+// TLS is used if a TLS configuration is specified. No connection is performed until the client is actually used.
+func NewWithOptions(network, address string, opts ...client.Option) client.Client {
+	return newClient(network, address, client.NewOptions(opts...), newGobCodec)
+}
+
+// NewWireWithOptions is like NewWithOptions, but the client uses the language-neutral binary wire format.
+func NewWireWithOptions(network, address string, opts ...client.Option) client.Client {
+	return newClient(network, address, client.NewOptions(opts...), newWireCodec)
+}
+
+func newClient(network, address string, o client.Options, newCodec newCodecFunc) client.Client {
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return bclient.DialContext(ctx, network, address, &o)
+	}
+	if o.TLSConfig != nil {
+		dial = dialTLS(dial, address, o.TLSConfig)
+	}
+	return bclient.NewLabeled(&tcpClient{
+		dial:         dial,
+		newCodec:     newCodec,
+		token:        o.Token,
+		readTimeout:  o.ReadTimeout,
+		writeTimeout: o.WriteTimeout,
+	}, o.OwnerLabel)
+}
+
+// dialTLS returns a function performing the TLS handshake on the connections established by dial.
+func dialTLS(dial dialFunc, address string, tlsConfig *tls.Config) dialFunc {
+	if tlsConfig.ServerName == "" && !tlsConfig.InsecureSkipVerify {
+		// verify the server certificate against the endpoint host
+		host, _, err := net.SplitHostPort(address)
+		if err == nil {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host
+		}
+	}
+	return func(ctx context.Context) (net.Conn, error) {
+		conn, err := dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tc := tls.Client(conn, tlsConfig)
+		err = tc.HandshakeContext(ctx)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+}
+
+// SetToken sets the token presented at the start of each new connection.
+func (c *tcpClient) SetToken(token string) {
+	c.token = token
 }
 
 // acquireConn is called every time a connection would be necessary; it does nothing if connection has already been made. It will re-estabilish a connection if Client c had been closed before.
-func (c *tcpClient) AcquireConn() error {
+func (c *tcpClient) AcquireConn(ctx context.Context) error {
 	if c.conn == nil {
 		var err error
-		c.conn, err = net.DialTCP("tcp", nil, c.endpoint)
+		c.conn, err = c.dial(ctx)
+		if err != nil {
+			return bclient.ContextError(ctx, err)
+		}
+		c.codec, err = c.newCodec(c.conn)
 		if err != nil {
+			_ = c.Close()
 			return err
 		}
-		if c.keepAlive != 0 {
-			// setup keep-alive
-			err = c.conn.SetKeepAlive(true)
-			if err != nil {
-				return err
-			}
-			err = c.conn.SetKeepAlivePeriod(c.keepAlive)
-			if err != nil {
-				return err
-			}
+
+		// no optional features are used
+		_, err = bclient.Hello(func(req *api.LockRequest) (*api.LockResponse, error) {
+			return c.Do(ctx, req)
+		}, 0, c.token)
+		if err != nil {
+			_ = c.Close()
+			return err
 		}
-		c.d = gob.NewDecoder(c.conn)
-		c.e = gob.NewEncoder(c.conn)
 	}
 	return nil
 }
 
-func (c *tcpClient) Do(req *api.LockRequest) (*api.LockResponse, error) {
-	if c.writeTimeout != 0 {
-		err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
-		if err != nil {
-			return nil, err
-		}
+// Do sends the request and waits for its response; when it fails or is interrupted by ctx the connection is closed, as it is left in an
+// unknown state, and the next request establishes a new one.
+func (c *tcpClient) Do(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
 	}
 
-	err := c.e.Encode(&req)
+	stop := bclient.Interrupt(ctx, c.conn.SetDeadline)
+	res, err := c.do(ctx, req)
+	stop()
+	if err != nil {
+		_ = c.Close()
+		return nil, bclient.ContextError(ctx, err)
+	}
+
+	return res, nil
+}
+
+func (c *tcpClient) do(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error) {
+	// deadlines are always set, also to clear the deadline of a previous interrupted request
+	err := bclient.SetDeadline(ctx, c.conn.SetWriteDeadline, c.writeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.codec.Encode(req)
 	if err != nil {
 		return nil, err
 	}
 
 	// wait for a response
 	var res api.LockResponse
-	if c.readTimeout != 0 {
-		err := c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
-		if err != nil {
-			return nil, err
-		}
+	err = bclient.SetDeadline(ctx, c.conn.SetReadDeadline, c.readTimeout)
+	if err != nil {
+		return nil, err
 	}
-	err = c.d.Decode(&res)
+	err = c.codec.Decode(&res)
 	if err != nil {
 		return nil, err
 	}
@@ -115,8 +231,7 @@ func (c *tcpClient) Close() error {
 		return err
 	}
 	c.conn = nil
-	c.d = nil
-	c.e = nil
+	c.codec = nil
 
 	return nil
 }
@@ -0,0 +1,111 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/tcp"
+	"github.com/gdm85/distrilock/api/client/ws"
+)
+
+const (
+	// locally running daemons with mutual TLS
+	defaultTLSServer          = "127.0.0.1:63429"
+	defaultWebsocketTLSServer = "wss://localhost:63529/distrilock"
+)
+
+// testTLSConfig returns the TLS configuration of test clients, with a client certificate if withCert is true.
+func testTLSConfig(t *testing.T, withCert bool) *tls.Config {
+	dir := os.Getenv("TLS_DIR")
+	if dir == "" {
+		t.Skip("no TLS certificates directory specified")
+	}
+
+	pem, err := ioutil.ReadFile(dir + "/ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		t.Fatal("invalid certificate authority")
+	}
+	config := &tls.Config{RootCAs: pool}
+
+	if withCert {
+		cert, err := tls.LoadX509KeyPair(dir+"/client.pem", dir+"/client.key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config
+}
+
+func TestMutualTLS(t *testing.T) {
+	a, err := net.ResolveTCPAddr("tcp", defaultTLSServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []client.Client{
+		tcp.NewTLS(a, testTLSConfig(t, true), time.Second*3, time.Second*2, time.Second*2),
+		ws.NewBinaryTLS(defaultWebsocketTLSServer, testTLSConfig(t, true), time.Second*3, time.Second*2, time.Second*2),
+		ws.NewJSONTLS(defaultWebsocketTLSServer, testTLSConfig(t, true), time.Second*3, time.Second*2, time.Second*2),
+	} {
+		l, err := c.Acquire(generateLockName(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = l.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = l.Release()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTLSClientCertificateRequired(t *testing.T) {
+	a, err := net.ResolveTCPAddr("tcp", defaultTLSServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []client.Client{
+		tcp.NewTLS(a, testTLSConfig(t, false), time.Second*3, time.Second*2, time.Second*2),
+		ws.NewBinaryTLS(defaultWebsocketTLSServer, testTLSConfig(t, false), time.Second*3, time.Second*2, time.Second*2),
+	} {
+		_, err := c.IsLocked(generateLockName(t))
+		if err == nil {
+			t.Fatal("expected connection without client certificate to fail")
+		}
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,99 @@
+package client_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/tcp"
+)
+
+// unixSocketDir returns the directory of the Unix domain sockets of the test daemons, if any.
+func unixSocketDir() string {
+	return os.Getenv("UNIX_SOCKET_DIR")
+}
+
+func unixSocket(t *testing.T, name string) string {
+	dir := unixSocketDir()
+	if dir == "" {
+		t.Skip("no Unix domain socket directory specified")
+	}
+	return dir + "/" + name
+}
+
+func TestUnixSessionOwnsLocks(t *testing.T) {
+	u := tcp.NewUnix(unixSocket(t, "a.sock"), time.Second*2, time.Second*2)
+	defer u.Close()
+
+	a, err := net.ResolveTCPAddr("tcp", defaultServerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := tcp.New(a, time.Second*3, time.Second*2, time.Second*2)
+	defer c.Close()
+
+	lockName := generateLockName(t)
+	l, err := u.Acquire(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the session record of the holder identifies the client process
+	expected := fmt.Sprintf("Failed: resource acquired through a different session (pid %d, uid %d)", os.Getpid(), os.Getuid())
+	_, err = c.Acquire(lockName)
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+
+	err = l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// closing the connection releases the lock
+	err = u.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		_, err = c.Acquire(lockName)
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnixUIDNotAllowed(t *testing.T) {
+	u := tcp.NewUnix(unixSocket(t, "b.sock"), time.Second*2, time.Second*2)
+	defer u.Close()
+
+	_, err := u.IsLocked(generateLockName(t))
+	if err == nil {
+		t.Fatal("expected connection to be rejected")
+	}
+	if _, ok := err.(*client.Error); ok {
+		t.Fatal("expected connection error, got", err)
+	}
+}
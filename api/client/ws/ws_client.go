@@ -17,10 +17,15 @@ with this program; if not, write to the Free Software Foundation, Inc.,
 */
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
 	"github.com/gdm85/distrilock/api"
@@ -33,10 +38,13 @@ import (
 // websocketClient is a single-connection, non-concurrency-safe client to a distrilock websocket daemon in binary or JSON mode.
 type websocketClient struct {
 	endpoint                  string
-	keepAlive                 time.Duration
+	dialer                    *websocket.Dialer
+	header                    http.Header
 	readTimeout, writeTimeout time.Duration
 	conn                      *websocket.Conn
 	messageType               int
+	// token is presented in the handshake of each new connection.
+	token string
 }
 
 // String returns a summary of the client connection and active locks.
@@ -44,63 +52,182 @@ func (c *websocketClient) String() string {
 	return fmt.Sprintf("%v", c.conn)
 }
 
+func init() {
+	client.RegisterScheme("ws", newFromURL)
+	client.RegisterScheme("wss", newFromURL)
+}
+
+// newFromURL returns a new client for a ws:// or wss:// URL; JSON messages are used with query format=json, binary messages otherwise.
+func newFromURL(u *url.URL, o client.Options) (client.Client, error) {
+	messageType := websocket.BinaryMessage
+	q := u.Query()
+	switch format := q.Get("format"); format {
+	case "", "binary":
+	case "json":
+		messageType = websocket.TextMessage
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	q.Del("format")
+	endpoint := *u
+	endpoint.RawQuery = q.Encode()
+
+	return newClient(endpoint.String(), o, messageType), nil
+}
+
 // NewBinary returns a new binary distrilock websocket client; no connection is performed.
 func NewBinary(endpoint string, keepAlive, readTimeout, writeTimeout time.Duration) client.Client {
-	return bclient.New(&websocketClient{
-		endpoint:     endpoint,
-		readTimeout:  readTimeout,
-		writeTimeout: writeTimeout,
-		keepAlive:    keepAlive,
-		messageType:  websocket.BinaryMessage,
-	})
+	return newClient(endpoint, client.Options{KeepAlive: keepAlive, ReadTimeout: readTimeout, WriteTimeout: writeTimeout}, websocket.BinaryMessage)
 }
 
 // NewJSON returns a new JSON distrilock websocket client; no connection is performed.
 func NewJSON(endpoint string, keepAlive, readTimeout, writeTimeout time.Duration) client.Client {
-	return bclient.New(&websocketClient{
+	return newClient(endpoint, client.Options{KeepAlive: keepAlive, ReadTimeout: readTimeout, WriteTimeout: writeTimeout}, websocket.TextMessage)
+}
+
+// NewBinaryTLS returns a new binary distrilock websocket client for a wss:// endpoint; no connection is performed.
+// Client certificates for mutual TLS can be specified in tlsConfig.
+func NewBinaryTLS(endpoint string, tlsConfig *tls.Config, keepAlive, readTimeout, writeTimeout time.Duration) client.Client {
+	return newClient(endpoint, client.Options{TLSConfig: tlsConfig, KeepAlive: keepAlive, ReadTimeout: readTimeout, WriteTimeout: writeTimeout}, websocket.BinaryMessage)
+}
+
+// NewJSONTLS returns a new JSON distrilock websocket client for a wss:// endpoint; no connection is performed.
+// Client certificates for mutual TLS can be specified in tlsConfig.
+func NewJSONTLS(endpoint string, tlsConfig *tls.Config, keepAlive, readTimeout, writeTimeout time.Duration) client.Client {
+	return newClient(endpoint, client.Options{TLSConfig: tlsConfig, KeepAlive: keepAlive, ReadTimeout: readTimeout, WriteTimeout: writeTimeout}, websocket.TextMessage)
+}
+
+// NewBinaryWithOptions returns a new binary distrilock websocket client for a ws:// or wss:// endpoint, with client.DefaultOptions changed
+// by opts; no connection is performed.
+func NewBinaryWithOptions(endpoint string, opts ...client.Option) client.Client {
+	return newClient(endpoint, client.NewOptions(opts...), websocket.BinaryMessage)
+}
+
+// NewJSONWithOptions is like NewBinaryWithOptions, but the client uses JSON messages.
+func NewJSONWithOptions(endpoint string, opts ...client.Option) client.Client {
+	return newClient(endpoint, client.NewOptions(opts...), websocket.TextMessage)
+}
+
+func newClient(endpoint string, o client.Options, messageType int) client.Client {
+	return bclient.NewLabeled(&websocketClient{
 		endpoint:     endpoint,
-		keepAlive:    keepAlive,
-		readTimeout:  readTimeout,
-		writeTimeout: writeTimeout,
-		messageType:  websocket.TextMessage,
-	})
+		dialer:       newDialer(&o),
+		header:       o.Header,
+		token:        o.Token,
+		readTimeout:  o.ReadTimeout,
+		writeTimeout: o.WriteTimeout,
+		messageType:  messageType,
+	}, o.OwnerLabel)
+}
+
+// newDialer returns a dialer according to o, which sets keep-alive on the underlying TCP connection also when TLS is used.
+func newDialer(o *client.Options) *websocket.Dialer {
+	d := *websocket.DefaultDialer
+	d.TLSClientConfig = o.TLSConfig
+	if o.DialTimeout != 0 {
+		d.HandshakeTimeout = o.DialTimeout
+	}
+	if o.Proxy != nil {
+		d.Proxy = o.Proxy
+	}
+	d.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return bclient.DialContext(ctx, network, addr, o)
+	}
+	return &d
+}
+
+// SetToken sets the token presented at the start of each new connection.
+func (c *websocketClient) SetToken(token string) {
+	c.token = token
 }
 
 // acquireConn is called every time a connection would be necessary; it does nothing if connection has already been made. It will re-estabilish a connection if Client c had been closed before.
-func (c *websocketClient) AcquireConn() error {
+func (c *websocketClient) AcquireConn(ctx context.Context) error {
 	if c.conn == nil {
 		var err error
-		c.conn, _, err = websocket.DefaultDialer.Dial(c.endpoint, nil)
+		c.conn, err = c.dial(ctx)
 		if err != nil {
-			return err
+			return bclient.ContextError(ctx, err)
 		}
-		if c.keepAlive != 0 {
-			uc := c.conn.UnderlyingConn()
-			conn, ok := uc.(*net.TCPConn)
-			if !ok {
-				return fmt.Errorf("found connection type %T, but %T expected", uc, conn)
-			}
-
-			// setup keep-alive
-			err = conn.SetKeepAlive(true)
-			if err != nil {
-				return err
-			}
-			err = conn.SetKeepAlivePeriod(c.keepAlive)
-			if err != nil {
-				return err
-			}
+
+		// no optional features are used
+		_, err = bclient.Hello(func(req *api.LockRequest) (*api.LockResponse, error) {
+			return c.Do(ctx, req)
+		}, 0, c.token)
+		if err != nil {
+			_ = c.Close()
+			return err
 		}
 	}
 	return nil
 }
 
-func (c *websocketClient) Do(req *api.LockRequest) (*api.LockResponse, error) {
-	if c.writeTimeout != 0 {
-		err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+// dial connects to the endpoint; the websocket handshake is interrupted when ctx is done, as the dialer honours only its deadline.
+func (c *websocketClient) dial(ctx context.Context) (*websocket.Conn, error) {
+	var (
+		lock    sync.Mutex
+		netConn net.Conn
+	)
+	d := *c.dialer
+	d.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		nc, err := c.dialer.NetDialContext(ctx, network, addr)
 		if err != nil {
 			return nil, err
 		}
+		lock.Lock()
+		defer lock.Unlock()
+		if ctx.Err() != nil {
+			// interruption happened before the connection was known
+			_ = nc.Close()
+			return nil, ctx.Err()
+		}
+		netConn = nc
+		return nc, nil
+	}
+
+	stop := bclient.Interrupt(ctx, func(t time.Time) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if netConn == nil {
+			return nil
+		}
+		return netConn.SetDeadline(t)
+	})
+	conn, _, err := d.DialContext(ctx, c.endpoint, c.header)
+	if stop() && err == nil {
+		// handshake completed, but the connection deadline was set by the interruption
+		_ = conn.UnderlyingConn().Close()
+		return nil, ctx.Err()
+	}
+	return conn, err
+}
+
+// Do sends the request and waits for its response; when it fails or is interrupted by ctx the connection is closed, as it is left in an
+// unknown state, and the next request establishes a new one.
+func (c *websocketClient) Do(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	stop := bclient.Interrupt(ctx, c.conn.UnderlyingConn().SetDeadline)
+	res, err := c.do(ctx, req)
+	stop()
+	if err != nil {
+		// a closing message cannot be sent anymore
+		_ = c.conn.UnderlyingConn().Close()
+		c.conn = nil
+		return nil, bclient.ContextError(ctx, err)
+	}
+
+	return res, nil
+}
+
+func (c *websocketClient) do(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error) {
+	// deadlines are always set, also to clear the deadline of a previous interrupted request
+	err := bclient.SetDeadline(ctx, c.conn.SetWriteDeadline, c.writeTimeout)
+	if err != nil {
+		return nil, err
 	}
 
 	w, err := c.conn.NextWriter(c.messageType)
@@ -122,11 +249,9 @@ func (c *websocketClient) Do(req *api.LockRequest) (*api.LockResponse, error) {
 
 	// wait for a response
 	var res api.LockResponse
-	if c.readTimeout != 0 {
-		err := c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
-		if err != nil {
-			return nil, err
-		}
+	err = bclient.SetDeadline(ctx, c.conn.SetReadDeadline, c.readTimeout)
+	if err != nil {
+		return nil, err
 	}
 
 	messageType, r, err := c.conn.NextReader()
@@ -150,22 +275,23 @@ func (c *websocketClient) Do(req *api.LockRequest) (*api.LockResponse, error) {
 	return &res, nil
 }
 
+// Close sends a closing message and closes the connection; the connection is closed also when the closing message cannot be sent.
 func (c *websocketClient) Close() error {
 	if c.conn == nil {
 		return nil
 	}
-	err := c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-	if err != nil {
-		return err
+	// the deadline of the last request might have passed already
+	err := c.conn.SetWriteDeadline(bclient.Deadline(context.Background(), c.writeTimeout))
+	if err == nil {
+		err = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 	}
 
 	//TODO: wait for ACK?
 
-	err = c.conn.Close()
+	closeErr := c.conn.Close()
+	c.conn = nil
 	if err != nil {
 		return err
 	}
-	c.conn = nil
-
-	return nil
+	return closeErr
 }
@@ -0,0 +1,276 @@
+package core
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gdm85/distrilock/api"
+)
+
+// Identity is the authenticated identity of a session.
+type Identity struct {
+	// Token is the shared-secret token presented by the session, if any.
+	Token string
+	// CommonName is the common name of the verified client certificate, if any.
+	CommonName string
+	// UID is the user ID of the peer process of a Unix domain socket, valid only if HasUID is true.
+	UID    uint32
+	HasUID bool
+}
+
+// String returns a description of the identity which does not disclose the token.
+func (id Identity) String() string {
+	var parts []string
+	if id.Token != "" {
+		parts = append(parts, "token")
+	}
+	if id.CommonName != "" {
+		parts = append(parts, "cn:"+id.CommonName)
+	}
+	if id.HasUID {
+		parts = append(parts, "uid:"+strconv.FormatUint(uint64(id.UID), 10))
+	}
+	if len(parts) == 0 {
+		return "anonymous"
+	}
+	return strings.Join(parts, ",")
+}
+
+// Identified is implemented by sessions which are not bound to a connection and carry their own identity.
+type Identified interface {
+	Identity() Identity
+}
+
+// aclEntry grants commands on lock names with the specified prefixes to an identity.
+type aclEntry struct {
+	// kind is one of "token", "cn", "uid" or "*" for any identity.
+	kind, value string
+	// prefixes are the allowed lock name prefixes; nil means any.
+	prefixes []string
+	// commands are the allowed commands; nil means any.
+	commands []api.LockCommand
+}
+
+var (
+	// acl is the access control list enforced by ProcessRequest; when nil, everything is allowed.
+	acl     []aclEntry
+	aclLock sync.RWMutex
+
+	// sessionTokens are the tokens presented by sessions.
+	sessionTokens     = map[Session]string{}
+	sessionTokensLock sync.RWMutex
+)
+
+// LoadACL loads the access control list from the file at path and starts enforcing it, replacing any previous one.
+// Each line of the file contains an identity, a comma-separated list of lock name prefixes and a comma-separated list of commands,
+// separated by spaces; identities are specified as "token:<secret>", "cn:<certificate common name>", "uid:<user ID>" or "*" for
+// any session, and "*" stands for any prefix or command. Empty lines and lines starting with '#' are ignored.
+// Requests are allowed when any entry matching the session identity allows them.
+func LoadACL(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries := []aclEntry{}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		e, err := parseACLEntry(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	aclLock.Lock()
+	acl = entries
+	aclLock.Unlock()
+
+	return nil
+}
+
+func parseACLEntry(line string) (aclEntry, error) {
+	var e aclEntry
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return e, fmt.Errorf("expected identity, prefixes and commands, found %d fields", len(fields))
+	}
+
+	if fields[0] == "*" {
+		e.kind = "*"
+	} else {
+		i := strings.IndexByte(fields[0], ':')
+		if i == -1 {
+			return e, fmt.Errorf("invalid identity %q", fields[0])
+		}
+		e.kind, e.value = fields[0][:i], fields[0][i+1:]
+		switch e.kind {
+		case "token", "cn":
+			if e.value == "" {
+				return e, fmt.Errorf("empty %s identity", e.kind)
+			}
+		case "uid":
+			if _, err := strconv.ParseUint(e.value, 10, 32); err != nil {
+				return e, fmt.Errorf("invalid user ID %q", e.value)
+			}
+		default:
+			return e, fmt.Errorf("unknown identity type %q", e.kind)
+		}
+	}
+
+	if fields[1] != "*" {
+		e.prefixes = strings.Split(fields[1], ",")
+	}
+
+	if fields[2] != "*" {
+		for _, name := range strings.Split(fields[2], ",") {
+			var c api.LockCommand
+			err := c.UnmarshalJSON([]byte(strconv.Quote(name)))
+			if err != nil || c == api.Hello {
+				return e, fmt.Errorf("invalid command %q", name)
+			}
+			e.commands = append(e.commands, c)
+		}
+	}
+
+	return e, nil
+}
+
+// matches returns true if the entry applies to the identity.
+func (e *aclEntry) matches(id Identity) bool {
+	switch e.kind {
+	case "*":
+		return true
+	case "token":
+		return id.Token != "" && subtle.ConstantTimeCompare([]byte(e.value), []byte(id.Token)) == 1
+	case "cn":
+		return id.CommonName == e.value
+	case "uid":
+		return id.HasUID && strconv.FormatUint(uint64(id.UID), 10) == e.value
+	}
+	return false
+}
+
+// allows returns true if the entry allows the command on the lock name.
+func (e *aclEntry) allows(command api.LockCommand, lockName string) bool {
+	if e.commands != nil {
+		found := false
+		for _, c := range e.commands {
+			if c == command {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if e.prefixes == nil {
+		return true
+	}
+	for _, prefix := range e.prefixes {
+		if strings.HasPrefix(lockName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowed returns true if the command on the lock name is allowed for the session by the access control list.
+func isAllowed(session Session, command api.LockCommand, lockName string) bool {
+	aclLock.RLock()
+	defer aclLock.RUnlock()
+	if acl == nil {
+		return true
+	}
+
+	id := IdentityOf(session)
+	for i := range acl {
+		if acl[i].matches(id) && acl[i].allows(command, lockName) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthenticateToken records the token presented by the session; it returns false if an access control list is enforced
+// and the token is not part of it.
+func AuthenticateToken(session Session, token string) bool {
+	aclLock.RLock()
+	known := acl == nil
+	for i := range acl {
+		if acl[i].kind == "token" && acl[i].matches(Identity{Token: token}) {
+			known = true
+			break
+		}
+	}
+	aclLock.RUnlock()
+	if !known {
+		return false
+	}
+
+	sessionTokensLock.Lock()
+	sessionTokens[session] = token
+	sessionTokensLock.Unlock()
+	return true
+}
+
+// IdentityOf returns the identity of the session: the token it presented, the verified client certificate of a TLS connection
+// and the user ID of a Unix domain socket peer.
+func IdentityOf(session Session) Identity {
+	var id Identity
+	switch s := session.(type) {
+	case Identified:
+		id = s.Identity()
+	case *UnixSession:
+		id.UID, id.HasUID = s.UID, true
+	case *tls.Conn:
+		if chains := s.ConnectionState().VerifiedChains; len(chains) != 0 {
+			id.CommonName = chains[0][0].Subject.CommonName
+		}
+	}
+
+	sessionTokensLock.RLock()
+	if token, ok := sessionTokens[session]; ok {
+		id.Token = token
+	}
+	sessionTokensLock.RUnlock()
+
+	return id
+}
+
+// forgetSession drops the token of a disconnected session.
+func forgetSession(session Session) {
+	sessionTokensLock.Lock()
+	delete(sessionTokens, session)
+	sessionTokensLock.Unlock()
+}
@@ -0,0 +1,75 @@
+package core
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"fmt"
+
+	"github.com/gdm85/distrilock/api"
+)
+
+// ProcessHello negotiates protocol version and optional features of a session and authenticates its token, if any; supported are
+// the features offered by the transport and first must be true only when this is the first request of the session.
+func ProcessHello(session Session, req api.LockRequest, supported api.Features, first bool) api.LockResponse {
+	var res api.LockResponse
+	res.LockRequest = req
+	// override with own version
+	res.VersionMajor, res.VersionMinor = api.VersionMajor, api.VersionMinor
+	res.Token = ""
+
+	if !first {
+		res.Result = api.BadRequest
+		res.Reason = "hello must be the first request of a session"
+		res.Features = 0
+		return res
+	}
+
+	if !isSupportedVersion(req) {
+		res.Result = api.UnsupportedVersion
+		res.Reason = unsupportedVersionReason(req)
+		res.Features = 0
+		return res
+	}
+
+	if req.Token != "" && !AuthenticateToken(session, req.Token) {
+		res.Result = api.Forbidden
+		res.Reason = "unknown token"
+		res.Features = 0
+		return res
+	}
+
+	res.Result = api.Success
+	res.Features = req.Features & supported
+
+	return res
+}
+
+// isSupportedVersion returns true if the protocol version of the request is supported.
+func isSupportedVersion(req api.LockRequest) bool {
+	return req.VersionMajor <= api.VersionMajor
+}
+
+// legacyResult returns result as known by the client of the request, which has detailed results since protocol version 0.5.
+func legacyResult(req api.LockRequest, result api.LockCommandResult) api.LockCommandResult {
+	if req.VersionMajor == 0 && req.VersionMinor < 5 {
+		return result.Legacy()
+	}
+	return result
+}
+
+func unsupportedVersionReason(req api.LockRequest) string {
+	return fmt.Sprintf("unsupported protocol version %d.%d, daemon supports up to %d.x", req.VersionMajor, req.VersionMinor, api.VersionMajor)
+}
@@ -0,0 +1,87 @@
+package core
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+)
+
+// outcomeRetention is how long the outcome of an acquisition is remembered for retries.
+const outcomeRetention = time.Minute
+
+// outcome is the recorded result of an acquisition request.
+type outcome struct {
+	requestID uint64
+	path      string
+	result    api.LockCommandResult
+	reason    string
+	at        time.Time
+}
+
+var (
+	// recentOutcomes contains the recent acquisition outcomes for each owner; protected by knownResourcesLock.
+	recentOutcomes = map[string][]outcome{}
+	lastPrune      time.Time
+)
+
+// findOutcome returns the recorded outcome for the specified owner and request; knownResourcesLock must be held.
+func findOutcome(ownerID string, requestID uint64) (outcome, bool) {
+	for _, o := range recentOutcomes[ownerID] {
+		if o.requestID == requestID && time.Since(o.at) < outcomeRetention {
+			return o, true
+		}
+	}
+	return outcome{}, false
+}
+
+// recordOutcome records the outcome of an acquisition; knownResourcesLock must be held for writing.
+func recordOutcome(ownerID string, o outcome) {
+	now := time.Now()
+	o.at = now
+
+	if now.Sub(lastPrune) >= outcomeRetention {
+		for owner, outcomes := range recentOutcomes {
+			recentOutcomes[owner] = pruneOutcomes(outcomes, now)
+			if len(recentOutcomes[owner]) == 0 {
+				delete(recentOutcomes, owner)
+			}
+		}
+		lastPrune = now
+	}
+
+	outcomes := pruneOutcomes(recentOutcomes[ownerID], now)
+	for i := range outcomes {
+		if outcomes[i].requestID == o.requestID {
+			// keep chronological order by moving the updated outcome to the end
+			outcomes = append(outcomes[:i], outcomes[i+1:]...)
+			break
+		}
+	}
+	recentOutcomes[ownerID] = append(outcomes, o)
+}
+
+// pruneOutcomes drops outcomes which are past their retention period.
+func pruneOutcomes(outcomes []outcome, now time.Time) []outcome {
+	// outcomes are always appended in chronological order
+	for i, o := range outcomes {
+		if now.Sub(o.at) < outcomeRetention {
+			return outcomes[i:]
+		}
+	}
+	return nil
+}
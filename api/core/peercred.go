@@ -0,0 +1,72 @@
+package core
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// PeerCredentials are the credentials of the process at the other end of a Unix domain socket.
+type PeerCredentials struct {
+	PID      int32
+	UID, GID uint32
+}
+
+// UnixSession is the session of a Unix domain socket connection; it records the credentials of the process holding its locks.
+type UnixSession struct {
+	Conn *net.UnixConn
+	PeerCredentials
+}
+
+// NewUnixSession returns the session of a Unix domain socket connection, reading the peer credentials with SO_PEERCRED.
+func NewUnixSession(conn *net.UnixConn) (*UnixSession, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		cred    *syscall.Ucred
+		credErr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+
+	return &UnixSession{Conn: conn, PeerCredentials: PeerCredentials{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}}, nil
+}
+
+// String returns a description of the peer process.
+func (s *UnixSession) String() string {
+	return fmt.Sprintf("pid %d, uid %d", s.PID, s.UID)
+}
+
+// differentSessionReason returns the reason of a failure due to the lock being held by session by; the holder process is
+// described when known.
+func differentSessionReason(by Session) string {
+	if s, ok := by.(*UnixSession); ok {
+		return "resource acquired through a different session (" + s.String() + ")"
+	}
+	return "resource acquired through a different session"
+}
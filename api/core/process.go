@@ -17,9 +17,11 @@ with this program; if not, write to the Free Software Foundation, Inc.,
 */
 
 import (
-	"net"
+	"fmt"
 	"os"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 
@@ -29,29 +31,54 @@ import (
 const lockExt = ".lck"
 
 var (
-	validLockNameRx    = regexp.MustCompile(`^[A-Za-z0-9.\-_]+$`)
+	validLockNameRx = regexp.MustCompile(`^[A-Za-z0-9.\-_]+$`)
+	// knownResources are the open lock files by path, so that daemons serving different directories can share a process.
 	knownResources     = map[string]*os.File{}
-	resourceAcquiredBy = map[*os.File]*net.TCPConn{}
+	resourceAcquiredBy = map[*os.File]Session{}
+	resourceOwnedBy    = map[*os.File]string{}
 	knownResourcesLock sync.RWMutex
 )
 
+// Session identifies the session through which locks are acquired; any comparable value can be used, e.g. the connection itself.
+type Session interface{}
+
 // ProcessRequest will process the lock command request and return a response.
-func ProcessRequest(directory string, client *net.TCPConn, req api.LockRequest) api.LockResponse {
+func ProcessRequest(directory string, client Session, req api.LockRequest) api.LockResponse {
 	var res api.LockResponse
 	res.LockRequest = req
 	// override with own version
 	res.VersionMajor, res.VersionMinor = api.VersionMajor, api.VersionMinor
+	res.Token = ""
+
+	if !isSupportedVersion(req) {
+		res.Result = api.UnsupportedVersion
+		res.Reason = unsupportedVersionReason(req)
+		return res
+	}
+
+	if req.Command == api.MySession {
+		// the session can always list its own locks
+		res.Result = api.Success
+		res.LockNames = sessionLocks(client, directory)
+		return res
+	}
 
 	// validate lock name
 	if !validLockNameRx.MatchString(req.LockName) {
-		res.Result = api.BadRequest
+		res.Result = legacyResult(req, api.InvalidLockName)
 		res.Reason = "invalid lock name"
 		return res
 	}
 
+	if !isAllowed(client, req.Command, req.LockName) {
+		res.Result = api.Forbidden
+		res.Reason = fmt.Sprintf("%v on lock %s is not allowed for %v", req.Command, req.LockName, IdentityOf(client))
+		return res
+	}
+
 	switch res.Command {
 	case api.Acquire:
-		res.Result, res.Reason = acquire(client, req.LockName, directory)
+		res.Result, res.Reason = acquireOnce(client, req, directory)
 	case api.Release:
 		res.Result, res.Reason = release(client, req.LockName, directory)
 	case api.Peek:
@@ -62,12 +89,13 @@ func ProcessRequest(directory string, client *net.TCPConn, req api.LockRequest)
 		res.Result = api.BadRequest
 		res.Reason = "unknown command"
 	}
+	res.Result = legacyResult(req, res.Result)
 
 	return res
 }
 
 // ProcessDisconnect releases sessions and resources associated to the disconnected client.
-func ProcessDisconnect(client *net.TCPConn) {
+func ProcessDisconnect(client Session) {
 	knownResourcesLock.Lock()
 
 	var filesToDrop []*os.File
@@ -84,21 +112,86 @@ func ProcessDisconnect(client *net.TCPConn) {
 
 			filesToDrop = append(filesToDrop, f)
 			delete(resourceAcquiredBy, f)
+			delete(resourceOwnedBy, f)
 		}
 	}
 	for _, droppedF := range filesToDrop {
-		for name, f := range knownResources {
+		for path, f := range knownResources {
 			if f == droppedF {
-				delete(knownResources, name)
+				delete(knownResources, path)
 				break
 			}
 		}
 	}
 
 	knownResourcesLock.Unlock()
+
+	forgetSession(client)
+}
+
+// sessionLocks returns the sorted names of the locks held by the client session in directory.
+func sessionLocks(client Session, directory string) []string {
+	knownResourcesLock.RLock()
+	defer knownResourcesLock.RUnlock()
+
+	var names []string
+	for path, f := range knownResources {
+		if resourceAcquiredBy[f] != client || !strings.HasPrefix(path, directory) {
+			continue
+		}
+		name := strings.TrimSuffix(path[len(directory):], lockExt)
+		if validLockNameRx.MatchString(name) {
+			// not in a subdirectory of directory
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
 }
 
-func shortAcquire(client *net.TCPConn, f *os.File, fullLock bool) (api.LockCommandResult, string) {
+// HoldsLocks returns true if the client session holds any lock.
+func HoldsLocks(client Session) bool {
+	knownResourcesLock.RLock()
+	defer knownResourcesLock.RUnlock()
+
+	for _, by := range resourceAcquiredBy {
+		if by == client {
+			return true
+		}
+	}
+	return false
+}
+
+// ReleaseAll releases all locks held by the client session and deletes their files, as if the client released each of them;
+// it is meant for daemon shutdown, while ProcessDisconnect keeps the files of interrupted sessions. The first error is returned.
+func ReleaseAll(client Session) error {
+	knownResourcesLock.Lock()
+	defer knownResourcesLock.Unlock()
+
+	var firstErr error
+	for path, f := range knownResources {
+		if resourceAcquiredBy[f] != client {
+			continue
+		}
+
+		err := releaseLock(f)
+		// closing the file releases the lock anyway
+		_ = f.Close()
+		delete(knownResources, path)
+		delete(resourceAcquiredBy, f)
+		delete(resourceOwnedBy, f)
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func shortAcquire(client Session, f *os.File, fullLock bool) (api.LockCommandResult, string) {
 	// check if lock was acquired by a different client
 	by, ok := resourceAcquiredBy[f]
 	if fullLock {
@@ -110,7 +203,7 @@ func shortAcquire(client *net.TCPConn, f *os.File, fullLock bool) (api.LockComma
 		panic("BUG: missing resource acquired by record")
 	}
 	if by != client {
-		return api.Failed, "resource acquired through a different session"
+		return api.HeldByOtherSession, differentSessionReason(by)
 	}
 
 	// lock was already acquired by this session, and it must still be held by us
@@ -119,10 +212,50 @@ func shortAcquire(client *net.TCPConn, f *os.File, fullLock bool) (api.LockComma
 	return api.Success, "no-op"
 }
 
-func acquire(client *net.TCPConn, lockName, directory string) (api.LockCommandResult, string) {
+// acquireOnce makes acquisitions idempotent: an acquisition retried by the same owner with the same request ID
+// is answered with the outcome of the original request instead of being processed again.
+func acquireOnce(client Session, req api.LockRequest, directory string) (api.LockCommandResult, string) {
+	if req.OwnerID == "" || req.RequestID == 0 {
+		// legacy clients do not identify their requests
+		return acquire(client, req.OwnerID, req.LockName, directory)
+	}
+
+	path := directory + req.LockName + lockExt
+
+	knownResourcesLock.Lock()
+	o, ok := findOutcome(req.OwnerID, req.RequestID)
+	if ok && o.path == path {
+		if o.result != api.Success {
+			knownResourcesLock.Unlock()
+			return o.result, o.reason
+		}
+
+		f, ok := knownResources[path]
+		if ok && resourceOwnedBy[f] == req.OwnerID {
+			// original acquisition is still valid; the lock now belongs to the session retrying the request
+			resourceAcquiredBy[f] = client
+			knownResourcesLock.Unlock()
+			return o.result, o.reason
+		}
+		// lock was lost meanwhile (e.g. the original session disconnected), thus it is acquired again
+	}
+	knownResourcesLock.Unlock()
+
+	result, reason := acquire(client, req.OwnerID, req.LockName, directory)
+
+	knownResourcesLock.Lock()
+	recordOutcome(req.OwnerID, outcome{requestID: req.RequestID, path: path, result: result, reason: reason})
+	knownResourcesLock.Unlock()
+
+	return result, reason
+}
+
+func acquire(client Session, ownerID, lockName, directory string) (api.LockCommandResult, string) {
+	path := directory + lockName + lockExt
+
 	knownResourcesLock.RLock()
 
-	f, ok := knownResources[lockName]
+	f, ok := knownResources[path]
 	if ok {
 		return shortAcquire(client, f, false)
 	}
@@ -130,13 +263,13 @@ func acquire(client *net.TCPConn, lockName, directory string) (api.LockCommandRe
 	knownResourcesLock.Lock()
 
 	// check again, as meanwhile lock could have been created
-	f, ok = knownResources[lockName]
+	f, ok = knownResources[path]
 	if ok {
 		return shortAcquire(client, f, true)
 	}
 
 	var err error
-	f, err = os.OpenFile(directory+lockName+lockExt, os.O_RDWR|os.O_CREATE, 0664)
+	f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0664)
 	if err != nil {
 		knownResourcesLock.Unlock()
 
@@ -150,7 +283,7 @@ func acquire(client *net.TCPConn, lockName, directory string) (api.LockCommandRe
 
 		if e, ok := err.(syscall.Errno); ok {
 			if e == syscall.EAGAIN || e == syscall.EACCES { // to be POSIX-compliant, both errors must be checked
-				return api.Failed, "resource acquired by different process"
+				return api.HeldByOtherProcess, "resource acquired by different process"
 			}
 		}
 
@@ -160,7 +293,8 @@ func acquire(client *net.TCPConn, lockName, directory string) (api.LockCommandRe
 	// writing to file is avoided as it's not necessary
 
 	resourceAcquiredBy[f] = client
-	knownResources[lockName] = f
+	resourceOwnedBy[f] = ownerID
+	knownResources[path] = f
 	knownResourcesLock.Unlock()
 
 	// successful lock acquire
@@ -168,10 +302,12 @@ func acquire(client *net.TCPConn, lockName, directory string) (api.LockCommandRe
 }
 
 func peek(lockName, directory string) (api.LockCommandResult, string, bool) {
+	path := directory + lockName + lockExt
+
 	knownResourcesLock.RLock()
 	defer knownResourcesLock.RUnlock()
 
-	f, ok := knownResources[lockName]
+	f, ok := knownResources[path]
 	if ok {
 		// same as the no-op in acquire(), it is assumed here that lock is still held by this process
 		return api.Success, "", true
@@ -179,7 +315,7 @@ func peek(lockName, directory string) (api.LockCommandResult, string, bool) {
 
 	var err error
 	// differently from acquire(), file must exist here
-	f, err = os.OpenFile(directory+lockName+lockExt, os.O_RDONLY, 0664)
+	f, err = os.OpenFile(path, os.O_RDONLY, 0664)
 	if err != nil {
 		if e, ok := err.(*os.PathError); ok {
 			if e.Err == syscall.ENOENT {
@@ -198,13 +334,15 @@ func peek(lockName, directory string) (api.LockCommandResult, string, bool) {
 	return api.Success, "", !isUnlocked
 }
 
-func release(client *net.TCPConn, lockName, directory string) (api.LockCommandResult, string) {
+func release(client Session, lockName, directory string) (api.LockCommandResult, string) {
+	path := directory + lockName + lockExt
+
 	knownResourcesLock.RLock()
 
-	f, ok := knownResources[lockName]
+	f, ok := knownResources[path]
 	if !ok {
 		knownResourcesLock.RUnlock()
-		return api.Failed, "lock not found"
+		return api.NotHeld, "lock not found"
 	}
 
 	// check if lock was acquired by a different client
@@ -214,15 +352,15 @@ func release(client *net.TCPConn, lockName, directory string) (api.LockCommandRe
 	}
 	if by != client {
 		knownResourcesLock.RUnlock()
-		return api.Failed, "resource acquired through a different session"
+		return api.HeldByOtherSession, differentSessionReason(by)
 	}
 	knownResourcesLock.RUnlock()
 	knownResourcesLock.Lock()
 
-	f, ok = knownResources[lockName]
+	f, ok = knownResources[path]
 	if !ok {
 		knownResourcesLock.Unlock()
-		return api.Failed, "lock not found"
+		return api.NotHeld, "lock not found"
 	}
 
 	// check if lock was acquired by a different client
@@ -232,7 +370,7 @@ func release(client *net.TCPConn, lockName, directory string) (api.LockCommandRe
 	}
 	if by != client {
 		knownResourcesLock.Unlock()
-		return api.Failed, "resource acquired through a different session"
+		return api.HeldByOtherSession, differentSessionReason(by)
 	}
 
 	err := releaseLock(f)
@@ -241,10 +379,11 @@ func release(client *net.TCPConn, lockName, directory string) (api.LockCommandRe
 		return api.InternalError, err.Error()
 	}
 
-	delete(knownResources, lockName)
+	delete(knownResources, path)
 	delete(resourceAcquiredBy, f)
+	delete(resourceOwnedBy, f)
 	_ = f.Close()
-	err = os.Remove(directory + lockName + lockExt)
+	err = os.Remove(path)
 
 	knownResourcesLock.Unlock()
 
@@ -256,13 +395,15 @@ func release(client *net.TCPConn, lockName, directory string) (api.LockCommandRe
 }
 
 // verifyOwnership verifies that specified client has acquired lock through this node.
-func verifyOwnership(client *net.TCPConn, lockName, directory string) (api.LockCommandResult, string) {
+func verifyOwnership(client Session, lockName, directory string) (api.LockCommandResult, string) {
+	path := directory + lockName + lockExt
+
 	knownResourcesLock.RLock()
 
-	f, ok := knownResources[lockName]
+	f, ok := knownResources[path]
 	if !ok {
 		knownResourcesLock.RUnlock()
-		return api.Failed, "lock not found"
+		return api.NotHeld, "lock not found"
 	}
 
 	// check if lock was acquired by a different client
@@ -272,13 +413,13 @@ func verifyOwnership(client *net.TCPConn, lockName, directory string) (api.LockC
 		panic("BUG: missing resource acquired by record")
 	}
 	if by != client {
-		return api.Failed, "resource acquired through a different session"
+		return api.HeldByOtherSession, differentSessionReason(by)
 	}
 	knownResourcesLock.Lock()
-	f, ok = knownResources[lockName]
+	f, ok = knownResources[path]
 	if !ok {
 		knownResourcesLock.Unlock()
-		return api.Failed, "lock not found"
+		return api.NotHeld, "lock not found"
 	}
 
 	// check if lock was acquired by a different client
@@ -288,7 +429,7 @@ func verifyOwnership(client *net.TCPConn, lockName, directory string) (api.LockC
 	}
 	if by != client {
 		knownResourcesLock.Unlock()
-		return api.Failed, "resource acquired through a different session"
+		return api.HeldByOtherSession, differentSessionReason(by)
 	}
 
 	// lock was already acquired by self
@@ -298,7 +439,7 @@ func verifyOwnership(client *net.TCPConn, lockName, directory string) (api.LockC
 	if err != nil {
 		if e, ok := err.(syscall.Errno); ok {
 			if e == syscall.EAGAIN || e == syscall.EACCES { // to be POSIX-compliant, both errors must be checked
-				return api.Failed, "resource acquired by different process"
+				return api.HeldByOtherProcess, "resource acquired by different process"
 			}
 		}
 
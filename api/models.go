@@ -17,7 +17,9 @@ with this program; if not, write to the Free Software Foundation, Inc.,
 */
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 )
 
 // LockCommand is a lock command that the client can request.
@@ -26,11 +28,14 @@ type LockCommand uint8
 // LockCommandResult is the result of a lock command.
 type LockCommandResult uint8
 
+// Features is a set of optional protocol features negotiated through the Hello command.
+type Features uint32
+
 const (
 	// VersionMajor is the major version of the distrilock protocol
 	VersionMajor = 0
 	// VersionMinor is the minor version of the distrilock protocol
-	VersionMinor = 1
+	VersionMinor = 6
 )
 
 const (
@@ -44,6 +49,15 @@ const (
 	Release
 	// Verify is the command used to verify that a named lock has been acquired by the caller.
 	Verify
+	// Hello is the command used at connection start to negotiate protocol version and optional features.
+	Hello
+	// ShuttingDown is the event sent by the daemon to sessions with FeaturePushEvents when it is shutting down; it is never sent by clients.
+	ShuttingDown
+	// MySession is the command used to list the locks held by the session, e.g. to reconcile the client state after errors.
+	MySession
+
+	// maxCommand is the upper bound of valid commands.
+	maxCommand
 )
 
 const (
@@ -57,6 +71,27 @@ const (
 	BadRequest
 	// InternalError is returned when an unexpected internal error happened while serving the command.
 	InternalError
+	// UnsupportedVersion is returned when the protocol version of the request is not supported by the daemon.
+	UnsupportedVersion
+	// Forbidden is returned when the command is not allowed for the session identity by the daemon access control list.
+	Forbidden
+	// HeldByOtherSession is returned when the lock is held by a different session of the same daemon; clients before protocol version 0.5 receive Failed instead.
+	HeldByOtherSession
+	// HeldByOtherProcess is returned when the lock is held by a different process, e.g. another daemon sharing the directory; clients before protocol version 0.5 receive Failed instead.
+	HeldByOtherProcess
+	// NotHeld is returned when releasing or verifying a lock which is not held; clients before protocol version 0.5 receive Failed instead.
+	NotHeld
+	// InvalidLockName is returned when the lock name is not valid; clients before protocol version 0.5 receive BadRequest instead.
+	InvalidLockName
+)
+
+const (
+	// FeaturePipelining allows multiple requests in flight on the same connection, answered in any order and matched by request ID.
+	FeaturePipelining Features = 1 << iota
+	// FeaturePushEvents allows the daemon to send unsolicited event messages to the client.
+	FeaturePushEvents
+	// FeatureCompression allows compression of messages.
+	FeatureCompression
 )
 
 // LockRequest is a lock command request descriptor.
@@ -65,6 +100,14 @@ type LockRequest struct {
 	VersionMinor uint8
 	Command      LockCommand
 	LockName     string
+	// RequestID is a client-generated identifier of the request, unique for the OwnerID; a retried request must carry the same value.
+	RequestID uint64
+	// OwnerID is a client-generated identifier of the lock owner, stable across reconnections of the same client.
+	OwnerID string
+	// Features are the optional features requested by the client in a Hello request, or granted by the daemon in its response.
+	Features Features
+	// Token is the shared-secret token authenticating the session in a Hello request; it is never sent back in responses.
+	Token string
 }
 
 // LockResponse is a response to a LockRequest; it always embeds the request's command and lock name.
@@ -75,6 +118,8 @@ type LockResponse struct {
 	Reason string
 	// IsLocked is specified when peeking lock status.
 	IsLocked bool
+	// LockNames are the names of the locks held by the session, sorted, in MySession responses.
+	LockNames []string `json:",omitempty"`
 }
 
 func (lc LockCommand) String() string {
@@ -89,10 +134,42 @@ func (lc LockCommand) String() string {
 		return `Release`
 	case Verify:
 		return `Verify`
+	case Hello:
+		return `Hello`
+	case ShuttingDown:
+		return `ShuttingDown`
+	case MySession:
+		return `MySession`
 	}
 	return fmt.Sprintf("UNKNOWN_LOCK_COMMAND(%d)", lc)
 }
 
+// UnmarshalJSON decodes a lock command from either its numeric value or its name.
+func (lc *LockCommand) UnmarshalJSON(b []byte) error {
+	if len(b) == 0 || b[0] != '"' {
+		var v uint8
+		err := json.Unmarshal(b, &v)
+		if err != nil {
+			return err
+		}
+		*lc = LockCommand(v)
+		return nil
+	}
+
+	var name string
+	err := json.Unmarshal(b, &name)
+	if err != nil {
+		return err
+	}
+	for c := Peek; c < maxCommand; c++ {
+		if c.String() == name {
+			*lc = c
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown lock command %q", name)
+}
+
 // String returns the human-readable description of the lock command result.
 func (lcr LockCommandResult) String() string {
 	switch lcr {
@@ -106,6 +183,50 @@ func (lcr LockCommandResult) String() string {
 		return `BadRequest`
 	case InternalError:
 		return `InternalError`
+	case UnsupportedVersion:
+		return `UnsupportedVersion`
+	case Forbidden:
+		return `Forbidden`
+	case HeldByOtherSession:
+		return `HeldByOtherSession`
+	case HeldByOtherProcess:
+		return `HeldByOtherProcess`
+	case NotHeld:
+		return `NotHeld`
+	case InvalidLockName:
+		return `InvalidLockName`
 	}
 	return fmt.Sprintf("UNKNOWN_LOCK_COMMAND_RESULT(%d)", lcr)
 }
+
+// Legacy returns the result as known by clients before protocol version 0.5, which do not have detailed results.
+func (lcr LockCommandResult) Legacy() LockCommandResult {
+	switch lcr {
+	case HeldByOtherSession, HeldByOtherProcess, NotHeld:
+		return Failed
+	case InvalidLockName:
+		return BadRequest
+	}
+	return lcr
+}
+
+// String returns the human-readable list of features.
+func (f Features) String() string {
+	if f == 0 {
+		return `none`
+	}
+	var names []string
+	for _, feature := range []struct {
+		Features
+		name string
+	}{{FeaturePipelining, `pipelining`}, {FeaturePushEvents, `push-events`}, {FeatureCompression, `compression`}} {
+		if f&feature.Features != 0 {
+			names = append(names, feature.name)
+			f &^= feature.Features
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("UNKNOWN_FEATURES(%#x)", uint32(f)))
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,129 @@
+package server
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/wire"
+)
+
+// codec decodes the requests and encodes the responses of a session.
+type codec interface {
+	Decode(req *api.LockRequest) error
+	Encode(res *api.LockResponse) error
+}
+
+type gobCodec struct {
+	d *gob.Decoder
+	e *gob.Encoder
+}
+
+func (c gobCodec) Decode(req *api.LockRequest) error {
+	return c.d.Decode(req)
+}
+
+func (c gobCodec) Encode(res *api.LockResponse) error {
+	return c.e.Encode(res)
+}
+
+type wireCodec struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (c wireCodec) Decode(req *api.LockRequest) error {
+	return wire.ReadRequest(c.r, req)
+}
+
+func (c wireCodec) Encode(res *api.LockResponse) error {
+	return wire.WriteResponse(c.w, res)
+}
+
+// jsonCodec is a line-oriented codec with one JSON message per line.
+type jsonCodec struct {
+	s *bufio.Scanner
+	w io.Writer
+}
+
+func (c jsonCodec) Decode(req *api.LockRequest) error {
+	if !c.s.Scan() {
+		if c.s.Err() == nil {
+			return io.EOF
+		}
+		return c.s.Err()
+	}
+	*req = api.LockRequest{}
+	return json.Unmarshal(c.s.Bytes(), req)
+}
+
+func (c jsonCodec) Encode(res *api.LockResponse) error {
+	// the encoder terminates each message with a newline
+	return json.NewEncoder(c.w).Encode(res)
+}
+
+// newCodec selects the codec of the session from the first bytes sent by the client: the binary wire format preamble,
+// an opening brace for newline-delimited JSON or else a gob stream.
+func newCodec(conn net.Conn) (codec, error) {
+	r := bufio.NewReader(conn)
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if b[0] == '{' {
+		// a gob stream could start with the same byte as its first message length, but it is always
+		// followed by a type definition with a negative type ID, encoded with a leading 0xff byte
+		b, err = r.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if b[1] != 0xff {
+			s := bufio.NewScanner(r)
+			s.Buffer(make([]byte, 0, 4096), wire.MaxFrameSize)
+			return jsonCodec{s: s, w: conn}, nil
+		}
+	}
+
+	if b[0] == wire.Magic[0] {
+		var magic [len(wire.Magic)]byte
+		_, err = io.ReadFull(r, magic[:])
+		if err != nil {
+			return nil, err
+		}
+		if string(magic[:]) != wire.Magic {
+			return nil, fmt.Errorf("invalid wire format preamble %x", magic)
+		}
+		return wireCodec{r: r, w: conn}, nil
+	}
+
+	return gobCodec{d: gob.NewDecoder(r), e: gob.NewEncoder(conn)}, nil
+}
+
+// isConnError returns true if the error interrupted the stream of requests.
+func isConnError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == wire.ErrFrameTooLarge || err == bufio.ErrTooLong {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
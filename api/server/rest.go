@@ -0,0 +1,369 @@
+package server
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/core"
+)
+
+const (
+	// defaultSessionTimeout is the heartbeat timeout of sessions created without an explicit timeout.
+	defaultSessionTimeout = time.Second * 30
+	minSessionTimeout     = time.Second
+	maxSessionTimeout     = time.Minute * 10
+)
+
+// errClosed is returned when creating a session after the server was shut down.
+var errClosed = errors.New("server is shutting down")
+
+// restAPI serves the HTTP/JSON API, where sessions are explicit resources kept alive by heartbeats.
+type restAPI struct {
+	directory string
+
+	sessions     map[string]*restSession
+	sessionsLock sync.Mutex
+	// closed is set when the server is shut down; protected by sessionsLock.
+	closed bool
+}
+
+// restSession is a session of the HTTP/JSON API; it is used as the core session of its locks.
+type restSession struct {
+	ID       string
+	Timeout  string
+	Deadline time.Time
+
+	rest    *restAPI
+	timeout time.Duration
+	// commonName is the verified client certificate common name of the request which created the session, if any.
+	commonName string
+	timer      *time.Timer
+	// lock serialises requests of the session with its expiration.
+	lock   sync.Mutex
+	closed bool
+}
+
+func newRESTAPI(directory string) *restAPI {
+	return &restAPI{directory: directory, sessions: map[string]*restSession{}}
+}
+
+// register registers the HTTP/JSON API routes on mux.
+func (a *restAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("/sessions", a.handleSessions)
+	mux.HandleFunc("/sessions/", a.handleSession)
+	mux.HandleFunc("/locks/", a.handleLocks)
+}
+
+// handleSessions creates a session with POST /sessions?timeout=30s, authenticated by the bearer token of the request if any.
+func (a *restAPI) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	timeout := defaultSessionTimeout
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		var err error
+		timeout, err = time.ParseDuration(t)
+		if err != nil || timeout < minSessionTimeout || timeout > maxSessionTimeout {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("timeout must be a duration between %v and %v", minSessionTimeout, maxSessionTimeout))
+			return
+		}
+	}
+
+	s, err := a.newSession(timeout, requestCommonName(r))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == errClosed {
+			status = http.StatusServiceUnavailable
+		}
+		httpError(w, status, err.Error())
+		return
+	}
+	if token := requestToken(r); token != "" && !core.AuthenticateToken(s, token) {
+		s.expire(true)
+		httpError(w, http.StatusForbidden, "unknown token")
+		return
+	}
+
+	s.lock.Lock()
+	w.Header().Set("Location", "/sessions/"+s.ID)
+	writeJSON(w, http.StatusCreated, s)
+	s.lock.Unlock()
+}
+
+// handleSession serves heartbeats with PUT /sessions/{id}, session termination with DELETE /sessions/{id}
+// and lock operations with PUT, DELETE and GET /sessions/{id}/locks/{name}.
+func (a *restAPI) handleSession(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/sessions/"), "/")
+
+	s := a.session(parts[0])
+	if s == nil {
+		httpError(w, http.StatusNotFound, "session not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodPut:
+			// heartbeat
+		case http.MethodDelete:
+			s.expire(true)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			methodNotAllowed(w, http.MethodPut, http.MethodDelete)
+			return
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.closed {
+			httpError(w, http.StatusNotFound, "session not found")
+			return
+		}
+		s.heartbeat()
+		writeJSON(w, http.StatusOK, s)
+	case len(parts) == 3 && parts[1] == "locks":
+		var command api.LockCommand
+		switch r.Method {
+		case http.MethodPut:
+			command = api.Acquire
+		case http.MethodDelete:
+			command = api.Release
+		case http.MethodGet:
+			command = api.Verify
+		default:
+			methodNotAllowed(w, http.MethodPut, http.MethodDelete, http.MethodGet)
+			return
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.closed {
+			httpError(w, http.StatusNotFound, "session not found")
+			return
+		}
+		// any request on the session counts as heartbeat
+		s.heartbeat()
+		writeLockResponse(w, core.ProcessRequest(a.directory, s, newRESTRequest(command, parts[2])))
+	default:
+		httpError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// handleLocks peeks a lock with GET /locks/{name}.
+func (a *restAPI) handleLocks(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/locks/")
+	if strings.Contains(name, "/") {
+		httpError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	// peeking does not need a session, thus a transient one carries the identity of the request
+	s := &restSession{commonName: requestCommonName(r)}
+	if token := requestToken(r); token != "" && !core.AuthenticateToken(s, token) {
+		httpError(w, http.StatusForbidden, "unknown token")
+		return
+	}
+	writeLockResponse(w, core.ProcessRequest(a.directory, s, newRESTRequest(api.Peek, name)))
+	core.ProcessDisconnect(s)
+}
+
+func (a *restAPI) newSession(timeout time.Duration, commonName string) (*restSession, error) {
+	var b [16]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return nil, err
+	}
+
+	a.sessionsLock.Lock()
+	closed := a.closed
+	a.sessionsLock.Unlock()
+	if closed {
+		return nil, errClosed
+	}
+
+	s := &restSession{ID: hex.EncodeToString(b[:]), Timeout: timeout.String(), rest: a, timeout: timeout, commonName: commonName}
+	s.lock.Lock()
+	s.heartbeat()
+	s.timer = time.AfterFunc(timeout, func() {
+		s.expire(false)
+	})
+	s.lock.Unlock()
+
+	a.sessionsLock.Lock()
+	a.sessions[s.ID] = s
+	a.sessionsLock.Unlock()
+
+	return s, nil
+}
+
+// close prevents the creation of new sessions.
+func (a *restAPI) close() {
+	a.sessionsLock.Lock()
+	a.closed = true
+	a.sessionsLock.Unlock()
+}
+
+// holdsLocks returns true if any session holds a lock.
+func (a *restAPI) holdsLocks() bool {
+	a.sessionsLock.Lock()
+	defer a.sessionsLock.Unlock()
+	for _, s := range a.sessions {
+		if core.HoldsLocks(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// closeAll expires all sessions, deleting the files of their locks, and prevents the creation of new ones.
+func (a *restAPI) closeAll() {
+	a.sessionsLock.Lock()
+	a.closed = true
+	sessions := make([]*restSession, 0, len(a.sessions))
+	for _, s := range a.sessions {
+		sessions = append(sessions, s)
+	}
+	a.sessionsLock.Unlock()
+
+	for _, s := range sessions {
+		s.expire(true)
+	}
+}
+
+func (a *restAPI) session(id string) *restSession {
+	a.sessionsLock.Lock()
+	defer a.sessionsLock.Unlock()
+	return a.sessions[id]
+}
+
+// Identity returns the identity of the session, except for its token.
+func (s *restSession) Identity() core.Identity {
+	return core.Identity{CommonName: s.commonName}
+}
+
+// requestToken returns the bearer token of the request, if any.
+func requestToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(h, "Bearer ")
+}
+
+// requestCommonName returns the verified client certificate common name of the request, if any.
+func requestCommonName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// heartbeat extends the session deadline; it must be called with the session lock held.
+func (s *restSession) heartbeat() {
+	s.Deadline = time.Now().Add(s.timeout)
+}
+
+// expire terminates the session when its deadline has passed or when forced, releasing all its locks.
+func (s *restSession) expire(force bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	if !force {
+		if left := time.Until(s.Deadline); left > 0 {
+			// a heartbeat was received meanwhile
+			s.timer.Reset(left)
+			return
+		}
+	}
+	s.closed = true
+	s.timer.Stop()
+
+	s.rest.sessionsLock.Lock()
+	delete(s.rest.sessions, s.ID)
+	shutdown := s.rest.closed
+	s.rest.sessionsLock.Unlock()
+
+	if shutdown {
+		err := core.ReleaseAll(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error releasing locks:", err.Error())
+		}
+	}
+	core.ProcessDisconnect(s)
+}
+
+func newRESTRequest(command api.LockCommand, lockName string) api.LockRequest {
+	var req api.LockRequest
+	req.VersionMajor, req.VersionMinor = api.VersionMajor, api.VersionMinor
+	req.Command = command
+	req.LockName = lockName
+	return req
+}
+
+// writeLockResponse writes the response with an HTTP status matching its result.
+func writeLockResponse(w http.ResponseWriter, res api.LockResponse) {
+	status := http.StatusInternalServerError
+	switch res.Result {
+	case api.Success:
+		status = http.StatusOK
+	case api.Failed, api.HeldByOtherSession, api.HeldByOtherProcess, api.NotHeld:
+		status = http.StatusConflict
+	case api.BadRequest, api.InvalidLockName:
+		status = http.StatusBadRequest
+	case api.Forbidden:
+		status = http.StatusForbidden
+	}
+	writeJSON(w, status, res)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error writing JSON response:", err.Error())
+	}
+}
+
+func httpError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, struct{ Error string }{msg})
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
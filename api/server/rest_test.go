@@ -0,0 +1,130 @@
+package server
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+)
+
+func startREST(t *testing.T) (*httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "distrilock-rest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	newRESTAPI(dir + "/").register(mux)
+	srv := httptest.NewServer(mux)
+
+	return srv, func() {
+		srv.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// call performs an HTTP request and decodes the JSON response body into v, if not nil.
+func call(t *testing.T, srv *httptest.Server, method, path string, expectedStatus int, v interface{}) {
+	req, err := http.NewRequest(method, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != expectedStatus {
+		body, _ := ioutil.ReadAll(res.Body)
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, expectedStatus, res.StatusCode, body)
+	}
+	if v != nil {
+		err = json.NewDecoder(res.Body).Decode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func createSession(t *testing.T, srv *httptest.Server, timeout string) string {
+	var s struct{ ID string }
+	call(t, srv, http.MethodPost, "/sessions?timeout="+timeout, http.StatusCreated, &s)
+	return s.ID
+}
+
+func TestRESTSessions(t *testing.T) {
+	srv, stop := startREST(t)
+	defer stop()
+
+	a, b := createSession(t, srv, "30s"), createSession(t, srv, "30s")
+
+	var res api.LockResponse
+	call(t, srv, http.MethodGet, "/locks/rest-lock", http.StatusOK, &res)
+	if res.IsLocked {
+		t.Fatal("expected lock to be free")
+	}
+
+	call(t, srv, http.MethodPut, "/sessions/"+a+"/locks/rest-lock", http.StatusOK, &res)
+	call(t, srv, http.MethodPut, "/sessions/"+b+"/locks/rest-lock", http.StatusConflict, &res)
+	if res.Result != api.HeldByOtherSession {
+		t.Fatal("expected failure, got", res.Result)
+	}
+	call(t, srv, http.MethodGet, "/locks/rest-lock", http.StatusOK, &res)
+	if !res.IsLocked {
+		t.Fatal("expected lock to be held")
+	}
+	call(t, srv, http.MethodGet, "/sessions/"+a+"/locks/rest-lock", http.StatusOK, nil)
+	call(t, srv, http.MethodGet, "/sessions/"+b+"/locks/rest-lock", http.StatusConflict, nil)
+	call(t, srv, http.MethodDelete, "/sessions/"+b+"/locks/rest-lock", http.StatusConflict, nil)
+
+	call(t, srv, http.MethodDelete, "/sessions/"+a+"/locks/rest-lock", http.StatusOK, nil)
+	call(t, srv, http.MethodPut, "/sessions/"+b+"/locks/rest-lock", http.StatusOK, nil)
+
+	// terminating a session releases its locks
+	call(t, srv, http.MethodDelete, "/sessions/"+b, http.StatusNoContent, nil)
+	call(t, srv, http.MethodPut, "/sessions/"+b, http.StatusNotFound, nil)
+	call(t, srv, http.MethodPut, "/sessions/"+a+"/locks/rest-lock", http.StatusOK, nil)
+
+	call(t, srv, http.MethodPut, "/sessions/"+a+"/locks/invalid%20name", http.StatusBadRequest, nil)
+	call(t, srv, http.MethodPost, "/sessions?timeout=1h", http.StatusBadRequest, nil)
+}
+
+func TestRESTMissedHeartbeat(t *testing.T) {
+	srv, stop := startREST(t)
+	defer stop()
+
+	a, b := createSession(t, srv, "1s"), createSession(t, srv, "30s")
+
+	call(t, srv, http.MethodPut, "/sessions/"+a+"/locks/rest-heartbeat", http.StatusOK, nil)
+
+	// heartbeats keep the session alive beyond its timeout
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond * 500)
+		call(t, srv, http.MethodPut, "/sessions/"+a, http.StatusOK, nil)
+	}
+	call(t, srv, http.MethodPut, "/sessions/"+b+"/locks/rest-heartbeat", http.StatusConflict, nil)
+
+	time.Sleep(time.Millisecond * 1500)
+
+	call(t, srv, http.MethodPut, "/sessions/"+a, http.StatusNotFound, nil)
+	call(t, srv, http.MethodPut, "/sessions/"+b+"/locks/rest-heartbeat", http.StatusOK, nil)
+}
@@ -0,0 +1,421 @@
+// Package server implements the transports through which daemons serve the locks of package core: TCP and Unix domain
+// socket connections, websockets and the HTTP/JSON API. All transports of a process share the same lock state.
+package server
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/core"
+)
+
+// maxPipelinedRequests is the maximum number of requests processed concurrently for a single connection.
+const maxPipelinedRequests = 64
+
+// supportedFeatures are the optional protocol features offered to clients.
+const supportedFeatures = api.FeaturePipelining | api.FeaturePushEvents
+
+// shutdownPollInterval is how often Shutdown checks whether sessions released their locks.
+const shutdownPollInterval = time.Millisecond * 100
+
+// ErrServerClosed is returned by Serve after a call to Shutdown.
+var ErrServerClosed = errors.New("server closed")
+
+// Server serves distrilock sessions with locks in Directory over any number of listeners, websockets and the HTTP/JSON API.
+// The zero value is not usable, as Directory must be set; a Server must not be copied after first use.
+type Server struct {
+	// Directory is the directory where lock files are created.
+	Directory string
+	// KeepAlive is the keep-alive period set on accepted TCP connections; keep-alive settings are not changed if zero.
+	KeepAlive time.Duration
+	// TLSConfig is used to serve TLS on TCP listeners, if not nil.
+	TLSConfig *tls.Config
+	// AllowedUIDs are the user IDs allowed to connect through Unix domain socket listeners; all are allowed when empty.
+	AllowedUIDs []uint32
+	// Handler serves the connections accepted by Serve in place of the distrilock protocol, if not nil; the connection is closed
+	// and the locks of session are released when it returns.
+	Handler func(conn net.Conn, session core.Session)
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	// active are the active sessions by connection.
+	active map[net.Conn]*activeSession
+	rest   *restAPI
+	closed bool
+	// shutdownEvent is pushed to sessions negotiating push events after Shutdown was called.
+	shutdownEvent *api.LockResponse
+	sessions      sync.WaitGroup
+}
+
+// activeSession is a session being served on a connection.
+type activeSession struct {
+	session core.Session
+	// push sends an event to the client; nil unless push events were negotiated.
+	push func(ev *api.LockResponse)
+}
+
+// directory returns Directory terminated by a path separator.
+func (s *Server) directory() string {
+	if s.Directory == "" {
+		return "./"
+	}
+	if !strings.HasSuffix(s.Directory, "/") {
+		return s.Directory + "/"
+	}
+	return s.Directory
+}
+
+// Serve accepts connections on l and serves each of them in a new goroutine; sessions of Unix domain socket listeners are identified
+// by the peer credentials. It returns when l fails with a non-temporary error, or with ErrServerClosed after Shutdown.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = map[net.Listener]struct{}{}
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				fmt.Fprintln(os.Stderr, "error accepting: ", err.Error())
+				continue
+			}
+			return err
+		}
+
+		switch c := conn.(type) {
+		case *net.UnixConn:
+			go s.serveUnix(c)
+		case *net.TCPConn:
+			go s.serveTCP(c)
+		default:
+			go s.serveConn(conn, conn)
+		}
+	}
+}
+
+func (s *Server) serveTCP(conn *net.TCPConn) {
+	if s.KeepAlive != 0 {
+		// setup keep-alive
+		err := conn.SetKeepAlive(true)
+		if err == nil {
+			err = conn.SetKeepAlivePeriod(s.KeepAlive)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not set keep alive: %v\n", err)
+			_ = conn.Close()
+			return
+		}
+	}
+
+	if s.TLSConfig != nil {
+		// the TLS connection identifies the session, as it carries the client certificate
+		tc := tls.Server(conn, s.TLSConfig)
+		s.serveConn(tc, tc)
+		return
+	}
+	s.serveConn(conn, conn)
+}
+
+// serveUnix serves a Unix domain socket connection, whose session carries the peer credentials; connections of peers whose user ID is not allowed are closed immediately.
+func (s *Server) serveUnix(conn *net.UnixConn) {
+	session, err := core.NewUnixSession(conn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading peer credentials: ", err.Error())
+		_ = conn.Close()
+		return
+	}
+	if !isAllowedUID(s.AllowedUIDs, session.UID) {
+		fmt.Fprintf(os.Stderr, "rejected connection of %v: user ID not allowed\n", session)
+		_ = conn.Close()
+		return
+	}
+
+	s.serveConn(conn, session)
+}
+
+func isAllowedUID(allowedUIDs []uint32, uid uint32) bool {
+	if len(allowedUIDs) == 0 {
+		return true
+	}
+	for _, allowed := range allowedUIDs {
+		if allowed == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// trackSession registers the connection of a new session; it returns false if the server was shut down.
+func (s *Server) trackSession(conn net.Conn, session core.Session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.active == nil {
+		s.active = map[net.Conn]*activeSession{}
+	}
+	s.active[conn] = &activeSession{session: session}
+	s.sessions.Add(1)
+	return true
+}
+
+// enablePush sets the function pushing events to the session on conn; the shutdown event is pushed at once if the server is shutting down.
+func (s *Server) enablePush(conn net.Conn, push func(ev *api.LockResponse)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	as, ok := s.active[conn]
+	if !ok {
+		return
+	}
+	as.push = push
+	if s.shutdownEvent != nil {
+		go push(s.shutdownEvent)
+	}
+}
+
+// endSession releases the locks of a session after its connection was closed; when shutting down, lock files are deleted as well.
+func (s *Server) endSession(conn net.Conn, session core.Session) {
+	if s.isClosed() {
+		err := core.ReleaseAll(session)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error releasing locks:", err.Error())
+		}
+	}
+	core.ProcessDisconnect(session)
+
+	s.mu.Lock()
+	delete(s.active, conn)
+	s.mu.Unlock()
+	s.sessions.Done()
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Shutdown stops all listeners, pushes a ShuttingDown event to sessions which negotiated push events and waits for all sessions to
+// release their locks; afterwards, or when ctx is done first, the remaining sessions are interrupted and their locks released with
+// their files deleted. The error of ctx is returned if some locks were still held. Sessions of the HTTP/JSON API are expired last;
+// servers of websockets and of the HTTP/JSON API must be shut down separately.
+func (s *Server) Shutdown(ctx context.Context) error {
+	ev := shutdownEvent(ctx)
+
+	s.mu.Lock()
+	s.closed = true
+	s.shutdownEvent = &ev
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	for _, as := range s.active {
+		if as.push != nil {
+			go as.push(&ev)
+		}
+	}
+	rest := s.rest
+	s.mu.Unlock()
+	if rest != nil {
+		rest.close()
+	}
+
+	// wait for clients to release their locks
+	t := time.NewTicker(shutdownPollInterval)
+	defer t.Stop()
+	var err error
+	for err == nil && !s.drained() {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	s.mu.Lock()
+	for conn := range s.active {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.sessions.Wait()
+
+	if rest != nil {
+		rest.closeAll()
+	}
+
+	return err
+}
+
+// drained returns true when no session holds any lock.
+func (s *Server) drained() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, as := range s.active {
+		if core.HoldsLocks(as.session) {
+			return false
+		}
+	}
+	return s.rest == nil || !s.rest.holdsLocks()
+}
+
+// shutdownEvent returns the event pushed to sessions when shutting down; its reason states the time left to release locks, if ctx has a deadline.
+func shutdownEvent(ctx context.Context) api.LockResponse {
+	var ev api.LockResponse
+	ev.VersionMajor, ev.VersionMinor = api.VersionMajor, api.VersionMinor
+	ev.Command = api.ShuttingDown
+	ev.Result = api.Success
+	ev.Reason = "daemon is shutting down"
+	if deadline, ok := ctx.Deadline(); ok {
+		ev.Reason += fmt.Sprintf(", locks still held will be released in %v", time.Until(deadline).Truncate(time.Millisecond))
+	}
+	return ev
+}
+
+// serveConn serves the requests of a connection, whose locks are acquired through session.
+// The request format (gob, binary wire format or newline-delimited JSON) is detected from the first bytes.
+func (s *Server) serveConn(conn net.Conn, session core.Session) {
+	if !s.trackSession(conn, session) {
+		_ = conn.Close()
+		return
+	}
+	defer s.endSession(conn, session)
+
+	if s.Handler != nil {
+		s.Handler(conn, session)
+		_ = conn.Close()
+		return
+	}
+
+	//fmt.Println("a client connected")
+
+	c, err := newCodec(conn)
+	if err != nil {
+		if err != io.EOF {
+			fmt.Fprintln(os.Stderr, "error reading:", err.Error())
+		}
+		_ = conn.Close()
+		return
+	}
+
+	// requests are processed in order, unless pipelining was granted with Hello: then they are
+	// processed concurrently and answered as soon as processed, possibly out of order
+	var (
+		writeLock sync.Mutex
+		inFlight  sync.WaitGroup
+		slots     = make(chan struct{}, maxPipelinedRequests)
+		first     = true
+		pipelined bool
+	)
+	process := func(req api.LockRequest) {
+		res := core.ProcessRequest(s.directory(), session, req)
+
+		writeLock.Lock()
+		err := c.Encode(&res)
+		writeLock.Unlock()
+		// an interrupted connection is detected by the reading loop
+		if err != nil && err != io.EOF {
+			fmt.Fprintln(os.Stderr, "Error writing:", err.Error())
+		}
+	}
+	for {
+		var req api.LockRequest
+		err = c.Decode(&req)
+		if err != nil {
+			if err == io.EOF {
+				// other end interrupted connection
+				break
+			}
+			fmt.Fprintln(os.Stderr, "error reading:", err.Error())
+			if isConnError(err) {
+				break
+			}
+			continue
+		}
+		//fmt.Println("received request:", req)
+
+		if req.Command == api.Hello {
+			res := core.ProcessHello(session, req, supportedFeatures, first)
+			first = false
+
+			writeLock.Lock()
+			err = c.Encode(&res)
+			writeLock.Unlock()
+			if err != nil && err != io.EOF {
+				fmt.Fprintln(os.Stderr, "Error writing:", err.Error())
+			}
+			if res.Result == api.Success && res.Features&api.FeaturePushEvents != 0 {
+				s.enablePush(conn, func(ev *api.LockResponse) {
+					writeLock.Lock()
+					// an interrupted connection is detected by the reading loop
+					_ = c.Encode(ev)
+					writeLock.Unlock()
+				})
+			}
+			if res.Result == api.Success {
+				pipelined = res.Features&api.FeaturePipelining != 0
+			}
+			continue
+		}
+		first = false
+
+		if !pipelined {
+			process(req)
+			continue
+		}
+
+		slots <- struct{}{}
+		inFlight.Add(1)
+		go func(req api.LockRequest) {
+			process(req)
+
+			<-slots
+			inFlight.Done()
+		}(req)
+	}
+
+	// requests still being processed could acquire locks for this session
+	inFlight.Wait()
+
+	_ = conn.Close()
+	//fmt.Println("a client disconnected")
+}
@@ -0,0 +1,135 @@
+package server
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/client"
+	"github.com/gdm85/distrilock/api/client/tcp"
+	"github.com/gdm85/distrilock/api/client/ws"
+)
+
+// startServer serves a new server with locks in a temporary directory on a local TCP listener; Serve errors are sent to the returned channel.
+func startServer(t *testing.T) (*Server, *net.TCPAddr, <-chan error, func()) {
+	dir, err := ioutil.TempDir("", "distrilock-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &Server{Directory: dir, KeepAlive: time.Second * 3}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(l)
+	}()
+
+	return srv, l.Addr().(*net.TCPAddr), errs, func() {
+		_ = srv.Shutdown(context.Background())
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestServeAndShutdown(t *testing.T) {
+	srv, addr, errs, cleanup := startServer(t)
+	defer cleanup()
+
+	c := tcp.New(addr, 0, time.Second*2, time.Second*2)
+	defer c.Close()
+	_, err := c.Acquire("embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// websocket sessions share the locks of the server
+	mux := http.NewServeMux()
+	srv.RegisterHTTP(mux)
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+	wc := ws.NewBinary("ws"+strings.TrimPrefix(hs.URL, "http")+"/distrilock", 0, time.Second*2, time.Second*2)
+	defer wc.Close()
+	_, err = wc.Acquire("embedded")
+	if !errors.Is(err, client.ErrHeldByOtherSession) {
+		t.Fatal("expected lock held by the TCP session, got", err)
+	}
+
+	// sessions still open when the context is done are interrupted
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err = srv.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatal("expected deadline exceeded, got", err)
+	}
+	if err = <-errs; err != ErrServerClosed {
+		t.Fatal("expected server closed, got", err)
+	}
+	_, err = os.Stat(filepath.Join(srv.Directory, "embedded.lck"))
+	if !os.IsNotExist(err) {
+		t.Fatal("expected lock file to be deleted, got", err)
+	}
+
+	// locks of interrupted sessions are released
+	srv2 := &Server{Directory: srv.Directory}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = srv2.Serve(l)
+	}()
+	c2 := tcp.New(l.Addr().(*net.TCPAddr), 0, time.Second*2, time.Second*2)
+	_, err = c2.Acquire("embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c2.Close()
+
+	// without sessions, shutdown completes immediately
+	err = srv2.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServersWithDifferentDirectories(t *testing.T) {
+	_, addr1, _, cleanup1 := startServer(t)
+	defer cleanup1()
+	_, addr2, _, cleanup2 := startServer(t)
+	defer cleanup2()
+
+	// servers in the same process do not share locks with the same name in different directories
+	for _, addr := range []*net.TCPAddr{addr1, addr2} {
+		c := tcp.New(addr, 0, time.Second*2, time.Second*2)
+		defer c.Close()
+		_, err := c.Acquire("same-name")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package server
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/core"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// RegisterHTTP registers on mux the websocket endpoint /distrilock and the routes of the HTTP/JSON API.
+func (s *Server) RegisterHTTP(mux *http.ServeMux) {
+	mux.Handle("/distrilock", s)
+
+	s.mu.Lock()
+	if s.rest == nil {
+		s.rest = newRESTAPI(s.directory())
+	}
+	rest := s.rest
+	s.mu.Unlock()
+
+	// HTTP/JSON API for clients which cannot keep a connection open
+	rest.register(mux)
+}
+
+// ServeHTTP upgrades the request to a websocket and serves its binary and JSON messages; locks are acquired through the underlying connection.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.isClosed() {
+		http.Error(w, "server closed", http.StatusServiceUnavailable)
+		return
+	}
+
+	wsconn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// print error continue serving other peers
+		fmt.Fprintf(os.Stderr, "upgrade: %v\n", err)
+		return
+	}
+
+	s.serveWebsocket(wsconn)
+}
+
+func (s *Server) serveWebsocket(wsconn *websocket.Conn) {
+	// the underlying connection identifies the session; keep-alive is set by the listener
+	conn := wsconn.UnderlyingConn()
+	if !s.trackSession(conn, conn) {
+		_ = wsconn.Close()
+		return
+	}
+	defer s.endSession(conn, conn)
+	//fmt.Println("a client connected")
+
+	// requests are processed in order, unless pipelining was granted with Hello: then they are
+	// processed concurrently and answered as soon as processed, possibly out of order
+	var (
+		writeLock sync.Mutex
+		inFlight  sync.WaitGroup
+		slots     = make(chan struct{}, maxPipelinedRequests)
+		first     = true
+		pipelined bool
+	)
+	process := func(messageType int, req api.LockRequest) {
+		res := core.ProcessRequest(s.directory(), conn, req)
+
+		writeLock.Lock()
+		writeResponse(wsconn, messageType, &res)
+		writeLock.Unlock()
+	}
+	for {
+		messageType, r, err := wsconn.NextReader()
+		if err != nil {
+			_, ok := err.(*websocket.CloseError)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "error getting next reader: %v\n", err)
+			}
+			// other end interrupted connection
+			break
+		}
+
+		var req api.LockRequest
+
+		switch messageType {
+		case websocket.BinaryMessage:
+			d := gob.NewDecoder(r)
+			err = d.Decode(&req)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error decoding binary message: %v\n", err)
+				continue
+			}
+		case websocket.TextMessage:
+			d := json.NewDecoder(r)
+			err = d.Decode(&req)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error decoding JSON message: %v\n", err)
+				continue
+			}
+		default:
+			panic("BUG: only BinaryMessage or TextMessage types expected")
+		}
+
+		if req.Command == api.Hello {
+			res := core.ProcessHello(conn, req, supportedFeatures, first)
+			first = false
+
+			writeLock.Lock()
+			writeResponse(wsconn, messageType, &res)
+			writeLock.Unlock()
+			if res.Result == api.Success && res.Features&api.FeaturePushEvents != 0 {
+				// events are sent with the same message type as the handshake
+				s.enablePush(conn, func(ev *api.LockResponse) {
+					writeLock.Lock()
+					writeResponse(wsconn, messageType, ev)
+					writeLock.Unlock()
+				})
+			}
+			if res.Result == api.Success {
+				pipelined = res.Features&api.FeaturePipelining != 0
+			}
+			continue
+		}
+		first = false
+
+		if !pipelined {
+			process(messageType, req)
+			continue
+		}
+
+		slots <- struct{}{}
+		inFlight.Add(1)
+		go func(messageType int, req api.LockRequest) {
+			process(messageType, req)
+
+			<-slots
+			inFlight.Done()
+		}(messageType, req)
+	}
+
+	// requests still being processed could acquire locks for this session
+	inFlight.Wait()
+
+	// Close the connection when you're done with it.
+	_ = wsconn.Close()
+	//fmt.Println("a client disconnected")
+}
+
+// writeResponse replies with same type as the request message.
+func writeResponse(wsconn *websocket.Conn, messageType int, res *api.LockResponse) {
+	w, err := wsconn.NextWriter(messageType)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error getting next writer: %v\n", err)
+		return
+	}
+
+	// send the response
+	switch messageType {
+	case websocket.BinaryMessage:
+		e := gob.NewEncoder(w)
+
+		err = e.Encode(res)
+		_ = w.Close()
+		if err != nil {
+			// an interrupted connection is detected by the reading loop
+			if _, ok := err.(*websocket.CloseError); !ok {
+				fmt.Fprintln(os.Stderr, "error writing binary response:", err.Error())
+			}
+		}
+	case websocket.TextMessage:
+		e := json.NewEncoder(w)
+
+		err = e.Encode(res)
+		_ = w.Close()
+		if err != nil && err != io.EOF {
+			fmt.Fprintln(os.Stderr, "error writing JSON response:", err.Error())
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Package wire implements the language-neutral binary wire format of the distrilock protocol, as specified in PROTOCOL.md.
+package wire
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gdm85/distrilock/api"
+)
+
+const (
+	// Magic is the preamble sent by the client at connection start to select the binary wire format.
+	Magic = "\xd1DL\x01"
+
+	// MaxFrameSize is the maximum size of a frame body.
+	MaxFrameSize = 65536
+
+	// lengthSize is the size of the frame length prefix.
+	lengthSize = 4
+	// requestFixedSize is the size of the fixed-size fields of a request.
+	requestFixedSize = 1 + 1 + 1 + 8 + 4
+)
+
+var (
+	// ErrFrameTooLarge is returned when a frame exceeds MaxFrameSize.
+	ErrFrameTooLarge = errors.New("frame too large")
+	// ErrStringTooLong is returned when a string cannot be encoded in a frame.
+	ErrStringTooLong = errors.New("string too long")
+	// ErrShortFrame is returned when a frame is too short for the fields it must contain.
+	ErrShortFrame = errors.New("short frame")
+)
+
+// MarshalRequest returns the frame of the request, including its length prefix.
+func MarshalRequest(req *api.LockRequest) ([]byte, error) {
+	b := make([]byte, lengthSize, lengthSize+requestFixedSize+2+len(req.LockName)+2+len(req.OwnerID)+2+len(req.Token))
+	b, err := appendRequest(b, req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Token != "" {
+		// the token is a trailing field of requests only, omitted when empty
+		b, err = appendString(b, req.Token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return finishFrame(b)
+}
+
+// MarshalResponse returns the frame of the response, including its length prefix.
+func MarshalResponse(res *api.LockResponse) ([]byte, error) {
+	b := make([]byte, lengthSize, lengthSize+requestFixedSize+2+len(res.LockName)+2+len(res.OwnerID)+1+1+2+len(res.Reason))
+	b, err := appendRequest(b, &res.LockRequest)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, byte(res.Result), boolByte(res.IsLocked))
+	b, err = appendString(b, res.Reason)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.LockNames) != 0 {
+		// the lock names are a trailing field of MySession responses only, omitted when empty
+		if len(res.LockNames) > 0xffff {
+			return nil, ErrFrameTooLarge
+		}
+		b = append(b, byte(len(res.LockNames)>>8), byte(len(res.LockNames)))
+		for _, name := range res.LockNames {
+			b, err = appendString(b, name)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return finishFrame(b)
+}
+
+// UnmarshalRequest decodes a frame body into req; trailing fields unknown to this implementation are ignored.
+func UnmarshalRequest(body []byte, req *api.LockRequest) error {
+	body, err := readRequest(body, req)
+	if err != nil || len(body) == 0 {
+		return err
+	}
+	req.Token, _, err = readString(body)
+	return err
+}
+
+// UnmarshalResponse decodes a frame body into res; trailing fields unknown to this implementation are ignored.
+func UnmarshalResponse(body []byte, res *api.LockResponse) error {
+	body, err := readRequest(body, &res.LockRequest)
+	if err != nil {
+		return err
+	}
+	if len(body) < 2 {
+		return ErrShortFrame
+	}
+	res.Result = api.LockCommandResult(body[0])
+	res.IsLocked = body[1] != 0
+	res.Reason, body, err = readString(body[2:])
+	if err != nil || len(body) == 0 {
+		return err
+	}
+	if len(body) < 2 {
+		return ErrShortFrame
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	res.LockNames = make([]string, n)
+	for i := range res.LockNames {
+		res.LockNames[i], body, err = readString(body)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteRequest writes the request frame to w with a single write.
+func WriteRequest(w io.Writer, req *api.LockRequest) error {
+	b, err := MarshalRequest(req)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// WriteResponse writes the response frame to w with a single write.
+func WriteResponse(w io.Writer, res *api.LockResponse) error {
+	b, err := MarshalResponse(res)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// ReadRequest reads a request frame from r.
+func ReadRequest(r io.Reader, req *api.LockRequest) error {
+	body, err := ReadFrame(r)
+	if err != nil {
+		return err
+	}
+	*req = api.LockRequest{}
+	return UnmarshalRequest(body, req)
+}
+
+// ReadResponse reads a response frame from r.
+func ReadResponse(r io.Reader, res *api.LockResponse) error {
+	body, err := ReadFrame(r)
+	if err != nil {
+		return err
+	}
+	*res = api.LockResponse{}
+	return UnmarshalResponse(body, res)
+}
+
+// ReadFrame reads a frame from r and returns its body; io.EOF is returned only if no byte of the frame could be read.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var l [lengthSize]byte
+	_, err := io.ReadFull(r, l[:])
+	if err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(l[:])
+	if size > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	body := make([]byte, size)
+	_, err = io.ReadFull(r, body)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return body, err
+}
+
+func appendRequest(b []byte, req *api.LockRequest) ([]byte, error) {
+	b = append(b, req.VersionMajor, req.VersionMinor, byte(req.Command))
+	b = appendUint64(b, req.RequestID)
+	b = appendUint32(b, uint32(req.Features))
+	b, err := appendString(b, req.LockName)
+	if err != nil {
+		return nil, err
+	}
+	return appendString(b, req.OwnerID)
+}
+
+func readRequest(body []byte, req *api.LockRequest) ([]byte, error) {
+	if len(body) < requestFixedSize {
+		return nil, ErrShortFrame
+	}
+	req.VersionMajor, req.VersionMinor, req.Command = body[0], body[1], api.LockCommand(body[2])
+	req.RequestID = binary.BigEndian.Uint64(body[3:])
+	req.Features = api.Features(binary.BigEndian.Uint32(body[11:]))
+	body = body[requestFixedSize:]
+
+	var err error
+	req.LockName, body, err = readString(body)
+	if err != nil {
+		return nil, err
+	}
+	req.OwnerID, body, err = readString(body)
+	return body, err
+}
+
+// finishFrame sets the length prefix of the frame.
+func finishFrame(b []byte) ([]byte, error) {
+	size := len(b) - lengthSize
+	if size > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	binary.BigEndian.PutUint32(b, uint32(size))
+	return b, nil
+}
+
+func appendString(b []byte, s string) ([]byte, error) {
+	if len(s) > 0xffff {
+		return nil, ErrStringTooLong
+	}
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...), nil
+}
+
+func readString(body []byte) (string, []byte, error) {
+	if len(body) < 2 {
+		return "", nil, ErrShortFrame
+	}
+	l := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	if len(body) < l {
+		return "", nil, fmt.Errorf("%v: string of %d bytes, %d available", ErrShortFrame, l, len(body))
+	}
+	return string(body[:l]), body[l:], nil
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return append(b, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,220 @@
+package wire_test
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/wire"
+)
+
+// golden frames are the reference for implementations in other languages; see PROTOCOL.md.
+var goldenRequests = []struct {
+	name  string
+	req   api.LockRequest
+	frame string
+}{
+	{
+		name: "acquire",
+		req:  api.LockRequest{VersionMajor: 0, VersionMinor: 3, Command: api.Acquire, LockName: "my-lock", RequestID: 1, OwnerID: "owner"},
+		frame: "0000001f" + // length
+			"00" + "03" + "02" + // version, command
+			"0000000000000001" + // request ID
+			"00000000" + // features
+			"0007" + "6d792d6c6f636b" + // lock name
+			"0005" + "6f776e6572", // owner ID
+	},
+	{
+		name: "hello with token",
+		req:  api.LockRequest{VersionMajor: 0, VersionMinor: 4, Command: api.Hello, Token: "s3cr3t"},
+		frame: "0000001b" +
+			"00" + "04" + "05" +
+			"0000000000000000" +
+			"00000000" +
+			"0000" +
+			"0000" +
+			"0006" + "733363723374", // token
+	},
+	{
+		name: "hello",
+		req:  api.LockRequest{VersionMajor: 0, VersionMinor: 3, Command: api.Hello, Features: api.FeaturePipelining},
+		frame: "00000013" +
+			"00" + "03" + "05" +
+			"0000000000000000" +
+			"00000001" +
+			"0000" +
+			"0000",
+	},
+}
+
+var goldenResponses = []struct {
+	name  string
+	res   api.LockResponse
+	frame string
+}{
+	{
+		name: "peek",
+		res: api.LockResponse{
+			LockRequest: api.LockRequest{VersionMajor: 0, VersionMinor: 3, Command: api.Peek, LockName: "x", RequestID: 2},
+			Result:      api.Success,
+			IsLocked:    true,
+		},
+		frame: "00000018" +
+			"00" + "03" + "01" +
+			"0000000000000002" +
+			"00000000" +
+			"0001" + "78" +
+			"0000" +
+			"02" + "01" + // result, is locked
+			"0000", // reason
+	},
+	{
+		name: "release failure",
+		res: api.LockResponse{
+			LockRequest: api.LockRequest{VersionMajor: 0, VersionMinor: 3, Command: api.Release, LockName: "a", RequestID: 7, OwnerID: "o"},
+			Result:      api.Failed,
+			Reason:      "lock not found",
+		},
+		frame: "00000027" +
+			"00" + "03" + "03" +
+			"0000000000000007" +
+			"00000000" +
+			"0001" + "61" +
+			"0001" + "6f" +
+			"01" + "00" +
+			"000e" + "6c6f636b206e6f7420666f756e64",
+	},
+	{
+		name: "my session",
+		res: api.LockResponse{
+			LockRequest: api.LockRequest{VersionMajor: 0, VersionMinor: 6, Command: api.MySession, RequestID: 3},
+			Result:      api.Success,
+			LockNames:   []string{"a", "bc"},
+		},
+		frame: "00000020" +
+			"00" + "06" + "07" +
+			"0000000000000003" +
+			"00000000" +
+			"0000" +
+			"0000" +
+			"02" + "00" +
+			"0000" +
+			"0002" + "0001" + "61" + "0002" + "6263", // lock names
+	},
+}
+
+func TestGoldenRequests(t *testing.T) {
+	for _, g := range goldenRequests {
+		b, err := wire.MarshalRequest(&g.req)
+		if err != nil {
+			t.Fatal(g.name, err)
+		}
+		if hex.EncodeToString(b) != g.frame {
+			t.Errorf("%s: expected frame %s, got %x", g.name, g.frame, b)
+		}
+
+		var req api.LockRequest
+		err = wire.ReadRequest(bytes.NewReader(b), &req)
+		if err != nil {
+			t.Fatal(g.name, err)
+		}
+		if req != g.req {
+			t.Errorf("%s: expected %+v, got %+v", g.name, g.req, req)
+		}
+	}
+}
+
+func TestGoldenResponses(t *testing.T) {
+	for _, g := range goldenResponses {
+		b, err := wire.MarshalResponse(&g.res)
+		if err != nil {
+			t.Fatal(g.name, err)
+		}
+		if hex.EncodeToString(b) != g.frame {
+			t.Errorf("%s: expected frame %s, got %x", g.name, g.frame, b)
+		}
+
+		var res api.LockResponse
+		err = wire.ReadResponse(bytes.NewReader(b), &res)
+		if err != nil {
+			t.Fatal(g.name, err)
+		}
+		if !reflect.DeepEqual(res, g.res) {
+			t.Errorf("%s: expected %+v, got %+v", g.name, g.res, res)
+		}
+	}
+}
+
+func TestTrailingFieldsIgnored(t *testing.T) {
+	// a frame from a future protocol version with an extra trailing field
+	b, err := hex.DecodeString("00000018" + "00" + "05" + "02" + "0000000000000001" + "00000000" + "0001" + "78" + "0000" + "0000" + "ffff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req api.LockRequest
+	err = wire.ReadRequest(bytes.NewReader(b), &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.VersionMinor != 5 || req.Command != api.Acquire || req.LockName != "x" || req.RequestID != 1 {
+		t.Error("unexpected request", req)
+	}
+}
+
+func TestInvalidFrames(t *testing.T) {
+	for _, frame := range []string{
+		// truncated fixed fields
+		"00000003" + "000302",
+		// lock name longer than frame
+		"00000013" + "00" + "03" + "02" + "0000000000000001" + "00000000" + "0009" + "78" + "00",
+	} {
+		b, err := hex.DecodeString(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var req api.LockRequest
+		err = wire.ReadRequest(bytes.NewReader(b), &req)
+		if err == nil {
+			t.Error("expected error for frame", frame)
+		}
+	}
+
+	// frame exceeding maximum size
+	var req api.LockRequest
+	err := wire.ReadRequest(bytes.NewReader([]byte{0x00, 0x01, 0x00, 0x01}), &req)
+	if err != wire.ErrFrameTooLarge {
+		t.Error("expected ErrFrameTooLarge, got", err)
+	}
+
+	// truncated body
+	err = wire.ReadRequest(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x20, 0x00}), &req)
+	if err != io.ErrUnexpectedEOF {
+		t.Error("expected io.ErrUnexpectedEOF, got", err)
+	}
+
+	// strings which cannot be represented
+	_, err = wire.MarshalRequest(&api.LockRequest{LockName: strings.Repeat("x", 0x10000)})
+	if err != wire.ErrStringTooLong {
+		t.Error("expected ErrStringTooLong, got", err)
+	}
+}
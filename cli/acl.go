@@ -0,0 +1,52 @@
+package flags
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gdm85/distrilock/api/core"
+)
+
+// SetupACL loads the access control list file at path, if specified, and reloads it on SIGHUP; the previous access control list
+// is kept when a reload fails.
+func SetupACL(path string) error {
+	if path == "" {
+		return nil
+	}
+	err := core.LoadACL(path)
+	if err != nil {
+		return err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			err := core.LoadACL(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not reload access control list: %v\n", err)
+				continue
+			}
+			fmt.Println("distrilock: access control list reloaded")
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,302 @@
+package main
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gdm85/distrilock/api"
+	"github.com/gdm85/distrilock/api/core"
+)
+
+var (
+	// knownScripts are the scripts loaded through SCRIPT LOAD or EVAL, by SHA1 digest.
+	knownScripts     = map[string]string{}
+	knownScriptsLock sync.RWMutex
+)
+
+// maxKnownScripts is the maximum number of cached scripts; Redis lock libraries use only a few.
+const maxKnownScripts = 1024
+
+// respSession is the state of a RESP connection; locks are bound to the connection session.
+type respSession struct {
+	directory string
+	session   core.Session
+	// values are the values set for the locks held by this session.
+	values map[string]string
+}
+
+// handleRequests serves the commands of a connection, whose locks are acquired through session; the connection is closed and the locks
+// released by the server when it returns.
+func handleRequests(directory string, conn net.Conn, session core.Session) {
+
+	s := respSession{directory: directory, session: session, values: map[string]string{}}
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if err == errProtocol {
+				_, _ = io.WriteString(conn, string(errorReply("ERR", "Protocol error")))
+			} else if err != io.EOF {
+				fmt.Fprintln(os.Stderr, "error reading:", err.Error())
+			}
+			break
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		rep, quit := s.process(args)
+
+		_, err = io.WriteString(conn, string(rep))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error writing:", err.Error())
+			break
+		}
+		if quit {
+			break
+		}
+	}
+}
+
+// process processes a command and returns its reply and whether the connection should be closed.
+func (s *respSession) process(args []string) (reply, bool) {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		if len(args) > 1 {
+			return bulkString(args[1]), false
+		}
+		return simpleString("PONG"), false
+	case "QUIT":
+		return simpleString("OK"), true
+	case "SELECT":
+		// only the default database is available
+		if len(args) != 2 || args[1] != "0" {
+			return errorReply("ERR", "DB index is out of range"), false
+		}
+		return simpleString("OK"), false
+	case "AUTH":
+		// the password is the token of the session, the user name is ignored
+		if len(args) != 2 && len(args) != 3 {
+			return wrongArgs(args[0]), false
+		}
+		if !core.AuthenticateToken(s.session, args[len(args)-1]) {
+			return errorReply("WRONGPASS", "invalid username-password pair or user is disabled."), false
+		}
+		return simpleString("OK"), false
+	case "SET":
+		return s.set(args[1:]), false
+	case "GET":
+		if len(args) != 2 {
+			return wrongArgs(args[0]), false
+		}
+		v, ok := s.values[args[1]]
+		if !ok {
+			return nullBulk, false
+		}
+		return bulkString(v), false
+	case "DEL":
+		if len(args) < 2 {
+			return wrongArgs(args[0]), false
+		}
+		return s.del(args[1:]), false
+	case "EXISTS":
+		if len(args) < 2 {
+			return wrongArgs(args[0]), false
+		}
+		return s.exists(args[1:]), false
+	case "EVAL":
+		if len(args) < 3 {
+			return wrongArgs(args[0]), false
+		}
+		cacheScript(args[1])
+		return s.eval(args[1], args[2:]), false
+	case "EVALSHA":
+		if len(args) < 3 {
+			return wrongArgs(args[0]), false
+		}
+		knownScriptsLock.RLock()
+		script, ok := knownScripts[strings.ToLower(args[1])]
+		knownScriptsLock.RUnlock()
+		if !ok {
+			return errorReply("NOSCRIPT", "No matching script. Please use EVAL."), false
+		}
+		return s.eval(script, args[2:]), false
+	case "SCRIPT":
+		if len(args) != 3 || strings.ToUpper(args[1]) != "LOAD" {
+			return errorReply("ERR", "only SCRIPT LOAD is supported"), false
+		}
+		return bulkString(cacheScript(args[2])), false
+	}
+
+	return errorReply("ERR", fmt.Sprintf("unknown command '%s'", args[0])), false
+}
+
+func wrongArgs(command string) reply {
+	return errorReply("ERR", fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(command)))
+}
+
+// set maps SET key value NX to an acquisition; expiration options are accepted but ignored, as locks are bound to the connection.
+func (s *respSession) set(args []string) reply {
+	if len(args) < 2 {
+		return wrongArgs("SET")
+	}
+	key, value := args[0], args[1]
+
+	nx := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "EX", "PX":
+			i++
+			if i == len(args) {
+				return errorReply("ERR", "syntax error")
+			}
+			if _, err := strconv.ParseUint(args[i], 10, 64); err != nil {
+				return errorReply("ERR", "value is not an integer or out of range")
+			}
+		default:
+			return errorReply("ERR", fmt.Sprintf("unsupported SET option '%s'", args[i]))
+		}
+	}
+	if !nx {
+		return errorReply("ERR", "only SET with NX is supported")
+	}
+
+	if _, ok := s.values[key]; ok {
+		// key exists, even if held by this same connection
+		return nullBulk
+	}
+
+	res := s.do(api.Acquire, key)
+	switch res.Result {
+	case api.Success:
+		s.values[key] = value
+		return simpleString("OK")
+	case api.Failed, api.HeldByOtherSession, api.HeldByOtherProcess:
+		return nullBulk
+	}
+	return resultError(res)
+}
+
+// del maps DEL to releases of the locks held by this connection; locks held by other connections are not deleted.
+func (s *respSession) del(keys []string) reply {
+	deleted := 0
+	for _, key := range keys {
+		if _, ok := s.values[key]; !ok {
+			continue
+		}
+		res := s.do(api.Release, key)
+		switch res.Result {
+		case api.Success:
+			deleted++
+			delete(s.values, key)
+		case api.Failed, api.NotHeld, api.HeldByOtherSession:
+			// lock is not held anymore
+			delete(s.values, key)
+		default:
+			return resultError(res)
+		}
+	}
+	return integer(deleted)
+}
+
+// exists maps EXISTS to peeking each lock.
+func (s *respSession) exists(keys []string) reply {
+	existing := 0
+	for _, key := range keys {
+		res := s.do(api.Peek, key)
+		if res.Result != api.Success {
+			return resultError(res)
+		}
+		if res.IsLocked {
+			existing++
+		}
+	}
+	return integer(existing)
+}
+
+// eval supports the compare-and-delete and compare-and-extend scripts used by Redis lock libraries.
+func (s *respSession) eval(script string, args []string) reply {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 0 || numKeys > len(args)-1 {
+		return errorReply("ERR", "Number of keys can't be greater than number of args")
+	}
+	keys, argv := args[1:1+numKeys], args[1+numKeys:]
+
+	l := strings.ToLower(script)
+	if !strings.Contains(l, "get") || len(keys) != 1 || len(argv) < 1 {
+		return errorReply("ERR", "only compare-and-delete and compare-and-extend scripts are supported")
+	}
+	key, value := keys[0], argv[0]
+
+	switch {
+	case strings.Contains(l, "del"):
+		if v, ok := s.values[key]; !ok || v != value {
+			return integer(0)
+		}
+		return s.del(keys)
+	case strings.Contains(l, "expire"):
+		// locks do not expire, thus extension succeeds as long as the lock is held with the same value
+		if v, ok := s.values[key]; !ok || v != value {
+			return integer(0)
+		}
+		return integer(1)
+	}
+
+	return errorReply("ERR", "only compare-and-delete and compare-and-extend scripts are supported")
+}
+
+func (s *respSession) do(command api.LockCommand, key string) api.LockResponse {
+	var req api.LockRequest
+	req.VersionMajor, req.VersionMinor = api.VersionMajor, api.VersionMinor
+	req.Command = command
+	req.LockName = key
+
+	return core.ProcessRequest(s.directory, s.session, req)
+}
+
+// resultError returns the error reply of an unsuccessful response.
+func resultError(res api.LockResponse) reply {
+	if res.Result == api.Forbidden {
+		return errorReply("NOPERM", res.Reason)
+	}
+	return errorReply("ERR", res.Reason)
+}
+
+// cacheScript caches the script and returns its SHA1 digest.
+func cacheScript(script string) string {
+	sum := sha1.Sum([]byte(script))
+	sha := hex.EncodeToString(sum[:])
+
+	knownScriptsLock.Lock()
+	if _, ok := knownScripts[sha]; !ok && len(knownScripts) < maxKnownScripts {
+		knownScripts[sha] = script
+	}
+	knownScriptsLock.Unlock()
+
+	return sha
+}
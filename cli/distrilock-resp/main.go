@@ -0,0 +1,108 @@
+// This package contains the command-line-interface executable to serve distrilock locks over a subset of the Redis RESP protocol.
+// To read its command line help, run:
+/* $ bin/distrilock-resp --help */
+package main
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/gdm85/distrilock/api/core"
+	"github.com/gdm85/distrilock/api/server"
+	"github.com/gdm85/distrilock/cli"
+)
+
+const defaultKeepAlive = time.Second * 3
+const defaultAddress = ":40802"
+
+func main() {
+	f, err := flags.Parse(os.Args, defaultAddress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	// print information about maximum number of files
+	noFile, err := flags.GetNumberOfFilesLimit()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(2)
+	}
+	if noFile <= 1024 {
+		// print maximum number of files only when it's a bit low
+		fmt.Println("distrilock-resp: maximum number of files allowed is", noFile)
+	}
+
+	tlsConfig, err := f.TLSConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(3)
+	}
+
+	err = flags.SetupACL(f.ACL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(3)
+	}
+
+	// RESP sessions have no push events, thus they are not notified when shutting down
+	srv := &server.Server{
+		Directory:   f.Directory,
+		KeepAlive:   defaultKeepAlive,
+		TLSConfig:   tlsConfig,
+		AllowedUIDs: f.AllowedUIDs,
+		Handler: func(conn net.Conn, session core.Session) {
+			handleRequests(f.Directory, conn, session)
+		},
+	}
+	done := flags.HandleShutdown("distrilock-resp", srv, f.Drain)
+
+	// Listen for incoming connections.
+	l, err := net.Listen("tcp", f.Address)
+	if err != nil {
+		fmt.Println("distrilock-resp: error listening:", err.Error())
+		os.Exit(4)
+	}
+	fmt.Println("distrilock-resp: listening on", l.Addr())
+
+	if f.Unix != "" {
+		ul, err := flags.ListenUnix(f.Unix)
+		if err != nil {
+			fmt.Println("distrilock-resp: error listening:", err.Error())
+			os.Exit(4)
+		}
+		fmt.Println("distrilock-resp: listening on", f.Unix)
+
+		go func() {
+			err := srv.Serve(ul)
+			if err != server.ErrServerClosed {
+				fmt.Println("distrilock-resp: error serving:", err.Error())
+				os.Exit(5)
+			}
+		}()
+	}
+
+	err = srv.Serve(l)
+	if err != server.ErrServerClosed {
+		fmt.Println("distrilock-resp: error serving:", err.Error())
+		os.Exit(5)
+	}
+	<-done
+}
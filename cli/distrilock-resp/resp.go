@@ -0,0 +1,118 @@
+package main
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxBulkLength is the maximum length of a bulk string accepted in commands.
+	maxBulkLength = 65536
+	// maxArrayLength is the maximum number of arguments of a command.
+	maxArrayLength = 1024
+)
+
+// errProtocol is returned when the client violates the RESP protocol; the connection cannot be recovered.
+var errProtocol = errors.New("protocol error")
+
+// readCommand reads a command as either an array of bulk strings or an inline command.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	if line[0] != '*' {
+		// inline command, as sent by telnet
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxArrayLength {
+		return nil, errProtocol
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err = readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		l, err := strconv.Atoi(line[1:])
+		if err != nil || l < 0 || l > maxBulkLength {
+			return nil, errProtocol
+		}
+		b := make([]byte, l+2)
+		_, err = io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+		if b[l] != '\r' || b[l+1] != '\n' {
+			return nil, errProtocol
+		}
+		args = append(args, string(b[:l]))
+	}
+
+	return args, nil
+}
+
+// readLine reads a CRLF-terminated line and returns it without terminator; lines cannot exceed the reader buffer size.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return "", errProtocol
+		}
+		if err == io.EOF && len(line) != 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// reply is a RESP reply.
+type reply string
+
+func simpleString(s string) reply {
+	return reply("+" + s + "\r\n")
+}
+
+func errorReply(kind, msg string) reply {
+	return reply("-" + kind + " " + msg + "\r\n")
+}
+
+func integer(i int) reply {
+	return reply(":" + strconv.Itoa(i) + "\r\n")
+}
+
+func bulkString(s string) reply {
+	return reply(fmt.Sprintf("$%d\r\n%s\r\n", len(s), s))
+}
+
+// nullBulk is the null bulk string, returned e.g. by a SET NX on an existing key.
+const nullBulk = reply("$-1\r\n")
@@ -0,0 +1,209 @@
+package main
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdm85/distrilock/api/core"
+	"github.com/gdm85/distrilock/api/server"
+)
+
+const compareAndDelete = `if redis.call("get",KEYS[1]) == ARGV[1] then return redis.call("del",KEYS[1]) else return 0 end`
+
+// respClient is a minimal RESP client.
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startDaemon(t *testing.T) (*net.TCPAddr, func()) {
+	dir, err := ioutil.TempDir("", "distrilock-resp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server.Server{
+		Directory: dir,
+		Handler: func(conn net.Conn, session core.Session) {
+			handleRequests(dir+"/", conn, session)
+		},
+	}
+	go func() {
+		_ = srv.Serve(l)
+	}()
+
+	return l.Addr().(*net.TCPAddr), func() {
+		// connections still open are interrupted
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		_ = srv.Shutdown(ctx)
+		cancel()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func dial(t *testing.T, addr *net.TCPAddr) *respClient {
+	conn, err := net.DialTCP("tcp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &respClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a command and returns its reply; bulk strings are returned without prefix, null as "(nil)".
+func (c *respClient) do(t *testing.T, args ...string) string {
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := c.conn.Write([]byte(cmd))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line[0] != '$' {
+		return line
+	}
+	l, err := strconv.Atoi(line[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l < 0 {
+		return "(nil)"
+	}
+	b := make([]byte, l+2)
+	_, err = io.ReadFull(c.r, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b[:l])
+}
+
+func expect(t *testing.T, c *respClient, expected string, args ...string) {
+	got := c.do(t, args...)
+	if got != expected {
+		t.Fatalf("%v: expected %q, got %q", args, expected, got)
+	}
+}
+
+func TestSetNXAndCompareAndDelete(t *testing.T) {
+	addr, stop := startDaemon(t)
+	defer stop()
+
+	a, b := dial(t, addr), dial(t, addr)
+	defer a.conn.Close()
+	defer b.conn.Close()
+
+	expect(t, a, "+PONG", "PING")
+	expect(t, a, ":0", "EXISTS", "resp-lock")
+	expect(t, a, "+OK", "SET", "resp-lock", "token-a", "NX", "PX", "30000")
+	expect(t, a, "(nil)", "SET", "resp-lock", "token-a", "NX")
+	expect(t, b, "(nil)", "SET", "resp-lock", "token-b", "NX", "PX", "30000")
+	expect(t, b, ":1", "EXISTS", "resp-lock")
+	expect(t, a, "token-a", "GET", "resp-lock")
+	expect(t, b, "(nil)", "GET", "resp-lock")
+
+	// only the owner with the right value can delete
+	expect(t, b, ":0", "EVAL", compareAndDelete, "1", "resp-lock", "token-b")
+	expect(t, a, ":0", "EVAL", compareAndDelete, "1", "resp-lock", "token-b")
+	expect(t, a, ":1", "EVAL", compareAndDelete, "1", "resp-lock", "token-a")
+
+	expect(t, b, ":0", "EXISTS", "resp-lock")
+	expect(t, b, "+OK", "SET", "resp-lock", "token-b", "NX")
+	expect(t, b, ":1", "DEL", "resp-lock")
+
+	expect(t, a, "-ERR invalid lock name", "SET", "resp/lock", "x", "NX")
+}
+
+func TestEvalSHA(t *testing.T) {
+	addr, stop := startDaemon(t)
+	defer stop()
+
+	a := dial(t, addr)
+	defer a.conn.Close()
+
+	expect(t, a, "-NOSCRIPT No matching script. Please use EVAL.", "EVALSHA", "0000000000000000000000000000000000000000", "1", "resp-sha", "x")
+
+	sha := a.do(t, "SCRIPT", "LOAD", compareAndDelete)
+	expect(t, a, "+OK", "SET", "resp-sha", "x", "NX")
+	expect(t, a, ":1", "EVALSHA", sha, "1", "resp-sha", "x")
+	expect(t, a, ":0", "EXISTS", "resp-sha")
+}
+
+func TestReleaseOnDisconnect(t *testing.T) {
+	addr, stop := startDaemon(t)
+	defer stop()
+
+	a, b := dial(t, addr), dial(t, addr)
+	defer b.conn.Close()
+
+	expect(t, a, "+OK", "SET", "resp-disconnect", "x", "NX")
+	expect(t, a, "+OK", "QUIT")
+	_ = a.conn.Close()
+
+	// disconnection is processed asynchronously
+	for i := 0; i < 100; i++ {
+		if b.do(t, "EXISTS", "resp-disconnect") == ":0" {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	expect(t, b, "+OK", "SET", "resp-disconnect", "y", "NX")
+}
+
+func TestNegativeArrayLength(t *testing.T) {
+	addr, stop := startDaemon(t)
+	defer stop()
+
+	for _, count := range []string{"*-1", "*-5"} {
+		c := dial(t, addr)
+		_, err := c.conn.Write([]byte(count + "\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != "-ERR Protocol error\r\n" {
+			t.Fatalf("%s: expected protocol error, got %q", count, line)
+		}
+		_ = c.conn.Close()
+	}
+
+	// daemon is still serving
+	c := dial(t, addr)
+	defer c.conn.Close()
+	expect(t, c, "+OK", "SET", "resp-negative", "x", "NX")
+}
@@ -24,25 +24,23 @@ import (
 	"os"
 	"time"
 
+	"github.com/gdm85/distrilock/api/server"
 	"github.com/gdm85/distrilock/cli"
-
-	"github.com/gorilla/websocket"
 )
 
 const defaultKeepAlive = time.Second * 3
 const defaultAddress = ":40801"
 
 func main() {
-	upgrader := websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-	}
-
 	f, err := flags.Parse(os.Args, defaultAddress)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		os.Exit(1)
 	}
+	if f.Unix != "" {
+		fmt.Fprintln(os.Stderr, "ERROR: Unix domain sockets are not supported by distrilock-ws")
+		os.Exit(1)
+	}
 
 	// print information about maximum number of files
 	noFile, err := flags.GetNumberOfFilesLimit()
@@ -55,21 +53,39 @@ func main() {
 		fmt.Println("distrilock: maximum number of files allowed is", noFile)
 	}
 
-	http.HandleFunc("/distrilock", func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			// print error continue serving other peers
-			fmt.Fprintf(os.Stderr, "upgrade: %v\n", err)
-			return
-		}
+	srv := &server.Server{Directory: f.Directory}
+	srv.RegisterHTTP(http.DefaultServeMux)
+
+	tlsConfig, err := f.TLSConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(3)
+	}
 
-		handleRequests(f.Directory, conn, defaultKeepAlive)
-	})
+	err = flags.SetupACL(f.ACL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(3)
+	}
 
-	fmt.Println("distrilock-ws: listening on", f.Address)
-	err = http.ListenAndServe(f.Address, nil)
+	l, err := flags.Listen(f.Address, defaultKeepAlive, tlsConfig)
 	if err != nil {
 		fmt.Println("error listening:", err)
 		os.Exit(3)
 	}
+	fmt.Println("distrilock-ws: listening on", l.Addr())
+
+	// HTTP/JSON API sessions can release their locks while draining, thus the HTTP server is closed last
+	done := flags.HandleShutdown("distrilock-ws", srv, f.Drain)
+	hs := &http.Server{}
+	go func() {
+		err := hs.Serve(l)
+		if err != http.ErrServerClosed {
+			fmt.Println("error serving:", err)
+			os.Exit(4)
+		}
+	}()
+
+	<-done
+	_ = hs.Close()
 }
@@ -24,6 +24,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/gdm85/distrilock/api/server"
 	"github.com/gdm85/distrilock/cli"
 )
 
@@ -48,28 +49,56 @@ func main() {
 		fmt.Println("distrilock: maximum number of files allowed is", noFile)
 	}
 
-	// validate address
-	addr, err := net.ResolveTCPAddr("tcp", f.Address)
+	tlsConfig, err := f.TLSConfig()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 		os.Exit(3)
 	}
 
+	err = flags.SetupACL(f.ACL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(3)
+	}
+
+	srv := &server.Server{
+		Directory:   f.Directory,
+		KeepAlive:   defaultKeepAlive,
+		TLSConfig:   tlsConfig,
+		AllowedUIDs: f.AllowedUIDs,
+	}
+
+	done := flags.HandleShutdown("distrilock", srv, f.Drain)
+
 	// Listen for incoming connections.
-	l, err := net.ListenTCP("tcp", addr)
+	l, err := net.Listen("tcp", f.Address)
 	if err != nil {
 		fmt.Println("distrilock: error listening:", err.Error())
 		os.Exit(4)
 	}
-	fmt.Println("distrilock: listening on", addr)
-	for {
-		// Listen for an incoming connection.
-		conn, err := l.AcceptTCP()
+	fmt.Println("distrilock: listening on", l.Addr())
+
+	if f.Unix != "" {
+		ul, err := flags.ListenUnix(f.Unix)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "error accepting: ", err.Error())
-			continue
+			fmt.Println("distrilock: error listening:", err.Error())
+			os.Exit(4)
 		}
-		// Handle connections in a new goroutine.
-		go handleRequests(f.Directory, conn, defaultKeepAlive)
+		fmt.Println("distrilock: listening on", f.Unix)
+
+		go func() {
+			err := srv.Serve(ul)
+			if err != server.ErrServerClosed {
+				fmt.Println("distrilock: error serving:", err.Error())
+				os.Exit(5)
+			}
+		}()
+	}
+
+	err = srv.Serve(l)
+	if err != server.ErrServerClosed {
+		fmt.Println("distrilock: error serving:", err.Error())
+		os.Exit(5)
 	}
+	<-done
 }
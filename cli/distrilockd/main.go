@@ -0,0 +1,141 @@
+// This package contains the command line interface executable 'distrilockd', which serves any combination of TCP,
+// websocket and Unix domain socket listeners sharing the same locks.
+// To read its command line help, run:
+/* $ bin/distrilockd --help */
+package main
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gdm85/distrilock/api/server"
+	"github.com/gdm85/distrilock/cli"
+)
+
+const defaultKeepAlive = time.Second * 3
+const defaultTCPAddress = ":40800"
+
+func main() {
+	f, err := flags.ParseMulti(os.Args, defaultTCPAddress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	// print information about maximum number of files
+	noFile, err := flags.GetNumberOfFilesLimit()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(2)
+	}
+	if noFile <= 1024 {
+		// print maximum number of files only when it's a bit low
+		fmt.Println("distrilockd: maximum number of files allowed is", noFile)
+	}
+
+	tlsConfig, err := f.TLSConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(3)
+	}
+
+	err = flags.SetupACL(f.ACL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(3)
+	}
+
+	srv := &server.Server{
+		Directory:   f.Directory,
+		KeepAlive:   defaultKeepAlive,
+		TLSConfig:   tlsConfig,
+		AllowedUIDs: f.AllowedUIDs,
+	}
+
+	// all listeners are opened before serving any of them
+	var tl, wl net.Listener
+	if f.TCP != "" {
+		tl, err = net.Listen("tcp", f.TCP)
+		if err != nil {
+			fmt.Println("distrilockd: error listening:", err.Error())
+			os.Exit(4)
+		}
+		fmt.Println("distrilockd: serving TCP on", tl.Addr())
+	}
+	if f.WS != "" {
+		wl, err = flags.Listen(f.WS, defaultKeepAlive, tlsConfig)
+		if err != nil {
+			fmt.Println("distrilockd: error listening:", err.Error())
+			os.Exit(4)
+		}
+		fmt.Println("distrilockd: serving websockets on", wl.Addr())
+	}
+	var ul net.Listener
+	if f.Unix != "" {
+		ul, err = flags.ListenUnix(f.Unix)
+		if err != nil {
+			fmt.Println("distrilockd: error listening:", err.Error())
+			os.Exit(4)
+		}
+		fmt.Println("distrilockd: serving Unix domain socket on", f.Unix)
+	}
+
+	done := flags.HandleShutdown("distrilockd", srv, f.Drain)
+
+	errs := make(chan error, 3)
+	if tl != nil {
+		go func() {
+			errs <- srv.Serve(tl)
+		}()
+	}
+	var hs *http.Server
+	if wl != nil {
+		mux := http.NewServeMux()
+		srv.RegisterHTTP(mux)
+		hs = &http.Server{Handler: mux}
+		go func() {
+			errs <- hs.Serve(wl)
+		}()
+	}
+	if ul != nil {
+		go func() {
+			errs <- srv.Serve(ul)
+		}()
+	}
+
+	for {
+		select {
+		case err = <-errs:
+			if err == server.ErrServerClosed {
+				// shutting down
+				continue
+			}
+			fmt.Println("distrilockd: error serving:", err.Error())
+			os.Exit(5)
+		case <-done:
+			// HTTP/JSON API sessions can release their locks while draining, thus the HTTP server is closed last
+			if hs != nil {
+				_ = hs.Close()
+			}
+			return
+		}
+	}
+}
@@ -1,4 +1,4 @@
-// Package flags defines the command line interface flags for both distrilock and distrilock-ws.
+// Package flags defines the command line interface flags and listeners shared by the distrilock daemons.
 package flags
 
 /* distrilock - https://github.com/gdm85/distrilock
@@ -17,35 +17,93 @@ with this program; if not, write to the Free Software Foundation, Inc.,
 */
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	flag "github.com/ogier/pflag"
 )
 
+// defaultDrain is the default period granted to sessions to release their locks when shutting down.
+const defaultDrain = time.Second * 10
+
 // Flags contains the command line interface flags for a distrilock daemon.
 type Flags struct {
 	*flag.FlagSet
 
 	Address   string
 	Directory string
+	// TCP and WS are the addresses to serve TCP and websocket clients on, for daemons serving multiple listeners.
+	TCP, WS string
+	// Unix is the path of the Unix domain socket to listen on in addition to Address, if any.
+	Unix string
+	// AllowedUIDs are the user IDs allowed to connect through the Unix domain socket; all are allowed when empty.
+	AllowedUIDs []uint32
+
+	// TLSCert and TLSKey are the paths of the PEM certificate and key used to serve TLS, if any.
+	TLSCert, TLSKey string
+	// TLSClientCA is the path of the PEM certificate authorities used to verify mandatory client certificates, if any.
+	TLSClientCA string
+
+	// ACL is the path of the access control list file, if any.
+	ACL string
+
+	// Drain is the period granted to sessions to release their locks when shutting down.
+	Drain time.Duration
 }
 
 // Parse parses valid command-line flags for distrilock or returns an error; if help flag was selected, it exits the process.
 func Parse(args []string, defaultAddress string) (*Flags, error) {
+	return parse(args, fmt.Sprintf("[--address=%s]", defaultAddress), func(f *Flags) {
+		f.FlagSet.StringVarP(&f.Address, "address", "a", defaultAddress, "address to listen on")
+		f.FlagSet.StringVarP(&f.Unix, "unix", "u", "", "path of a Unix domain socket to listen on, in addition to address")
+	})
+}
+
+// ParseMulti parses valid command-line flags for a daemon serving any combination of TCP, websocket and Unix domain
+// socket listeners or returns an error; if no listener is specified, TCP is served on defaultTCP. If help flag was selected, it exits the process.
+func ParseMulti(args []string, defaultTCP string) (*Flags, error) {
+	f, err := parse(args, "[--tcp=address] [--ws=address]", func(f *Flags) {
+		f.FlagSet.StringVar(&f.TCP, "tcp", "", fmt.Sprintf("address to serve TCP clients on (default %q if no listener is specified)", defaultTCP))
+		f.FlagSet.StringVar(&f.WS, "ws", "", "address to serve websocket and HTTP/JSON API clients on")
+		f.FlagSet.StringVarP(&f.Unix, "unix", "u", "", "path of a Unix domain socket to listen on")
+	})
+	if err != nil {
+		return nil, err
+	}
+	if f.TCP == "" && f.WS == "" && f.Unix == "" {
+		f.TCP = defaultTCP
+	}
+	return f, nil
+}
+
+// parse parses the flags common to all daemons and the listener flags added by addListenerFlags, described in usage.
+func parse(args []string, usage string, addListenerFlags func(*Flags)) (*Flags, error) {
 	if len(args) < 1 {
 		return nil, errors.New("empty arguments")
 	}
 	var f Flags
 	f.FlagSet = flag.NewFlagSet(args[0], flag.ExitOnError)
 
-	f.FlagSet.StringVarP(&f.Address, "address", "a", defaultAddress, "address to listen on")
+	addListenerFlags(&f)
 	f.FlagSet.StringVarP(&f.Directory, "directory", "d", ".", "directory where to locate locked files")
+	var allowedUIDs string
+	f.FlagSet.StringVar(&allowedUIDs, "allowed-uids", "", "comma-separated list of user IDs allowed to connect through the Unix domain socket")
+	f.FlagSet.StringVar(&f.TLSCert, "tls-cert", "", "PEM certificate file to serve TLS")
+	f.FlagSet.StringVar(&f.TLSKey, "tls-key", "", "PEM key file of the TLS certificate")
+	f.FlagSet.StringVar(&f.TLSClientCA, "tls-client-ca", "", "PEM certificate authorities file to require and verify client certificates")
+	f.FlagSet.StringVar(&f.ACL, "acl", "", "access control list file, reloaded on SIGHUP")
+	f.FlagSet.DurationVar(&f.Drain, "drain", defaultDrain, "period granted to sessions to release their locks on SIGTERM, before they are released forcibly")
 	f.FlagSet.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: distrilock [--address=%s] [--directory=.]\n\n", defaultAddress)
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [--directory=.] [--unix=path] [--allowed-uids=uid,...] [--tls-cert=file --tls-key=file [--tls-client-ca=file]] [--acl=file] [--drain=duration]\n\n", filepath.Base(args[0]), usage)
 		flag.PrintDefaults()
 	}
 
@@ -65,9 +123,63 @@ func Parse(args []string, defaultAddress string) (*Flags, error) {
 	}
 	f.Directory += "/"
 
+	if allowedUIDs != "" {
+		if f.Unix == "" {
+			return nil, errors.New("allowed user IDs specified without a Unix domain socket")
+		}
+		for _, uid := range strings.Split(allowedUIDs, ",") {
+			v, err := strconv.ParseUint(uid, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid user ID %q", uid)
+			}
+			f.AllowedUIDs = append(f.AllowedUIDs, uint32(v))
+		}
+	}
+
+	if (f.TLSCert == "") != (f.TLSKey == "") {
+		return nil, errors.New("TLS certificate and key must be specified together")
+	}
+	if f.Drain < 0 {
+		return nil, errors.New("negative drain period")
+	}
+	if f.TLSClientCA != "" && f.TLSCert == "" {
+		return nil, errors.New("TLS client certificate authorities specified without a TLS certificate")
+	}
+
 	return &f, nil
 }
 
+// TLSConfig returns the TLS configuration of the daemon, or nil if TLS is not enabled.
+func (f *Flags) TLSConfig() (*tls.Config, error) {
+	if f.TLSCert == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.TLSCert, f.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if f.TLSClientCA != "" {
+		pem, err := ioutil.ReadFile(f.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", f.TLSClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
 // GetNumberOfFilesLimit returns the (hard) limit for maximum number of files for the user running current process.
 func GetNumberOfFilesLimit() (uint64, error) {
 	var limit syscall.Rlimit
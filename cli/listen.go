@@ -0,0 +1,75 @@
+package flags
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Listen listens for TCP connections on address, enabling keep-alive on each accepted connection and serving TLS if tlsConfig is not nil.
+func Listen(address string, keepAlivePeriod time.Duration, tlsConfig *tls.Config) (net.Listener, error) {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var kl net.Listener = keepAliveListener{TCPListener: l, period: keepAlivePeriod}
+	if tlsConfig != nil {
+		// keep-alive is set on the underlying TCP connection
+		kl = tls.NewListener(kl, tlsConfig)
+	}
+	return kl, nil
+}
+
+// keepAliveListener enables keep-alive on accepted connections.
+type keepAliveListener struct {
+	*net.TCPListener
+	period time.Duration
+}
+
+// Accept accepts the next connection with keep-alive; connections where keep-alive cannot be set are dropped.
+func (l keepAliveListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.AcceptTCP()
+		if err != nil {
+			return nil, err
+		}
+
+		// setup keep-alive
+		err = conn.SetKeepAlive(true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not set keep alive: %v\n", err)
+			_ = conn.Close()
+			continue
+		}
+		err = conn.SetKeepAlivePeriod(l.period)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not set keep alive period: %v\n", err)
+			_ = conn.Close()
+			continue
+		}
+
+		return conn, nil
+	}
+}
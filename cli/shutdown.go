@@ -0,0 +1,50 @@
+package flags
+
+/* distrilock - https://github.com/gdm85/distrilock
+Copyright (C) 2017 gdm85
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gdm85/distrilock/api/server"
+)
+
+// HandleShutdown shuts srv down on SIGTERM or SIGINT, giving sessions the drain period to release their locks; remaining locks are
+// then released and their files deleted. The returned channel is closed once shutdown completed.
+func HandleShutdown(name string, srv *server.Server, drain time.Duration) <-chan struct{} {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+
+	done := make(chan struct{})
+	go func() {
+		<-sig
+		fmt.Printf("%s: shutting down, sessions have %v to release their locks\n", name, drain)
+
+		ctx, cancel := context.WithTimeout(context.Background(), drain)
+		err := srv.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			fmt.Printf("%s: drain period expired, remaining locks released\n", name)
+		}
+		close(done)
+	}()
+
+	return done
+}
@@ -20,6 +20,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/gdm85/distrilock/api/client/concurrent"
 	"github.com/gdm85/distrilock/api/client/tcp"
 )
 
@@ -30,8 +31,8 @@ func main() {
 		panic(err)
 	}
 
-	// create client
-	c := tcp.New(addr, time.Second*3, time.Second*3, time.Second*3)
+	// create client; it must be concurrency-safe for the lock monitor
+	c := concurrent.New(tcp.New(addr, time.Second*3, time.Second*3, time.Second*3))
 
 	// acquire lock
 	l, err := c.Acquire("my-named-lock")
@@ -39,6 +40,9 @@ func main() {
 		panic(err)
 	}
 
+	// verify lock health in background
+	l.Monitor(time.Second, nil)
+
 	const totalWorkUnits = 3
 	workDone := 0
 
@@ -53,14 +57,15 @@ func main() {
 			break
 		}
 
-		// verify lock is still in good health
-		err := l.Verify()
-		if err != nil {
+		// stop if lock was lost
+		select {
+		case err := <-l.Lost():
 			panic(err)
+		default:
 		}
 	}
 
-	// release lock
+	// release lock and stop its monitor
 	err = l.Release()
 	if err != nil {
 		panic(err)
@@ -9,6 +9,32 @@
 set -e
 
 TMPD="$(mktemp -d)"
+SOCKD="$(mktemp -d)"
+CERTD="$(mktemp -d)"
+SHUTDOWND="$(mktemp -d)"
+
+## generate a certificate authority and certificates signed by it for TLS daemons and clients
+function gen_cert() {
+	local NAME="$1" SUBJ="$2" EXT="$3"
+	openssl req -newkey rsa:2048 -nodes -subj "$SUBJ" -keyout "$CERTD/$NAME.key" -out "$CERTD/$NAME.csr" 2>/dev/null
+	echo "$EXT" > "$CERTD/$NAME.ext"
+	openssl x509 -req -in "$CERTD/$NAME.csr" -CA "$CERTD/ca.pem" -CAkey "$CERTD/ca.key" -CAcreateserial -days 1 \
+		-extfile "$CERTD/$NAME.ext" -out "$CERTD/$NAME.pem" 2>/dev/null
+}
+openssl req -x509 -newkey rsa:2048 -nodes -days 1 -subj "/CN=distrilock test CA" -addext "basicConstraints=critical,CA:TRUE" \
+	-keyout "$CERTD/ca.key" -out "$CERTD/ca.pem" 2>/dev/null
+gen_cert server "/CN=localhost" "subjectAltName=DNS:localhost,IP:127.0.0.1"
+gen_cert client "/CN=test-client" "extendedKeyUsage=clientAuth"
+TLS_OPTS="--tls-cert=$CERTD/server.pem --tls-key=$CERTD/server.key --tls-client-ca=$CERTD/ca.pem"
+
+## access control list, rewritten by tests and reloaded on SIGHUP
+ACL_FILE="$CERTD/acl.txt"
+cat > "$ACL_FILE" <<EOF
+# identity           prefixes  commands
+token:team-a-secret  team-a-   *
+cn:test-client       tls-      Acquire,Release
+*                    *         Peek
+EOF
 
 ###
 ### tcp daemons
@@ -16,14 +42,22 @@ TMPD="$(mktemp -d)"
 SVC=distrilock
 BASE=63419
 
-## local daemon A
-bin/$SVC --address=:$BASE --directory="$TMPD" &
+## local daemon A, also on a Unix domain socket
+bin/$SVC --address=:$BASE --directory="$TMPD" --unix="$SOCKD/a.sock" &
 A=$!
 
-## local daemon B
-bin/$SVC --address=:$[BASE+1] --directory="$TMPD" &
+## local daemon B, also on a Unix domain socket not allowed for current user
+bin/$SVC --address=:$[BASE+1] --directory="$TMPD" --unix="$SOCKD/b.sock" --allowed-uids=$[$(id -u)+1] &
 B=$!
 
+## local daemon with mutual TLS
+bin/$SVC --address=:$[BASE+10] --directory="$TMPD" $TLS_OPTS &
+G=$!
+
+## local daemon with mutual TLS and access control list
+bin/$SVC --address=:$[BASE+11] --directory="$TMPD" $TLS_OPTS --acl="$ACL_FILE" &
+I=$!
+
 if [ ! -z "$NFS_SHARE" ]; then
 	## local daemon C, on an NFS share
 	bin/$SVC --address=:$[BASE+2] --directory="$NFS_SHARE" &
@@ -47,13 +81,29 @@ D=$!
 bin/$SVC --address=localhost:$[BASE+1] --directory="$TMPD" &
 E=$!
 
+## local daemon with mutual TLS
+bin/$SVC --address=localhost:$[BASE+10] --directory="$TMPD" $TLS_OPTS &
+H=$!
+
 if [ ! -z "$NFS_SHARE" ]; then
 	## local daemon C, on an NFS share
 	bin/$SVC --address=localhost:$[BASE+2] --directory="$NFS_SHARE" &
 	F=$!
 fi
 
-trap "kill $A $B $C $D $E $F; rm -rf '$TMPD'" EXIT
+###
+### multi-listener daemon
+###
+
+## local daemon serving TCP, websockets and a Unix domain socket with shared locks
+bin/distrilockd --tcp=:63431 --ws=localhost:63531 --unix="$SOCKD/m.sock" --directory="$TMPD" &
+M=$!
+
+## local daemon shut down by tests with SIGTERM, with its own directory
+bin/distrilockd --tcp=:63432 --ws=localhost:63532 --directory="$SHUTDOWND" --drain=2s &
+S=$!
+
+trap "kill $A $B $C $D $E $F $G $H $I $M $S 2>/dev/null; rm -rf '$TMPD' '$SOCKD' '$CERTD' '$SHUTDOWND'" EXIT
 
 if [ -z "$TIMES" ]; then
 	TIMES=1
@@ -62,7 +112,7 @@ fi
 echo "Running all tests"
 set +e
 while [ $TIMES -gt 0 ]; do
-	LOCAL_LOCK_DIR="$TMPD" go test $OPTS "$@" || exit $?
+	LOCAL_LOCK_DIR="$TMPD" UNIX_SOCKET_DIR="$SOCKD" TLS_DIR="$CERTD" ACL_FILE="$ACL_FILE" ACL_DAEMON_PID=$I SHUTDOWN_LOCK_DIR="$SHUTDOWND" SHUTDOWN_DAEMON_PID=$S go test $OPTS "$@" || exit $?
 
 	let TIMES-=1
 done